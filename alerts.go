@@ -0,0 +1,128 @@
+package apcupsc
+
+import "time"
+
+// WentOnBatteryEvent is sent by Watch when a UPS starts running on
+// battery power.
+type WentOnBatteryEvent struct {
+	Addr   string
+	At     time.Time
+	Target *Target
+}
+
+// Time returns when the UPS was found to be on battery.
+func (e WentOnBatteryEvent) Time() time.Time { return e.At }
+
+// BatteryLowEvent is sent by Watch when a UPS reports LOWBATT, or its
+// charge or runtime falls below the thresholds set with
+// WithLowBattery.
+type BatteryLowEvent struct {
+	Addr   string
+	At     time.Time
+	Target *Target
+}
+
+// Time returns when the battery was found to be low.
+func (e BatteryLowEvent) Time() time.Time { return e.At }
+
+// CommunicationsLostEvent is sent by Watch when a daemon reports
+// COMMLOST, or when it has failed to answer a number of consecutive
+// polls (see WithCommLostAfter). Target is nil, and Err holds the
+// latest poll error, in the latter case.
+type CommunicationsLostEvent struct {
+	Addr   string
+	At     time.Time
+	Target *Target
+	Err    error
+}
+
+// Time returns when communications were found to be lost.
+func (e CommunicationsLostEvent) Time() time.Time { return e.At }
+
+// RecoveredEvent is sent by Watch once all of the conditions that
+// caused alert events have cleared.
+type RecoveredEvent struct {
+	Addr   string
+	At     time.Time
+	Target *Target
+}
+
+// Time returns when the UPS was found to have recovered.
+func (e RecoveredEvent) Time() time.Time { return e.At }
+
+// CommLostAfter is the default number of consecutive poll failures
+// after which Watch sends a CommunicationsLostEvent. It can be
+// overridden with WithCommLostAfter.
+var CommLostAfter = 3
+
+// alerter tracks the alert conditions of a watched UPS so that alert
+// events are only sent when a condition changes.
+type alerter struct {
+	cfg  *config
+	addr string
+
+	onBattery, low, commLost bool
+	failures                 int
+}
+
+// alerting reports whether any alert condition holds.
+func (a *alerter) alerting() bool {
+	return a.onBattery || a.low || a.commLost
+}
+
+// isLow reports whether t has a low battery. Once low, the battery
+// must recover past the thresholds by the configured hysteresis
+// before it is no longer considered low.
+func (a *alerter) isLow(t *Target) bool {
+	if t.HasStatus("LOWBATT") {
+		return true
+	}
+	charge, runtime := a.cfg.lowCharge, a.cfg.lowRuntime
+	if a.low {
+		charge += a.cfg.lowChargeHysteresis
+		runtime += a.cfg.lowRuntimeHysteresis
+	}
+	if a.cfg.lowCharge > 0 && t.Reported("BCharge") && t.BCharge < charge {
+		return true
+	}
+	return a.cfg.lowRuntime > 0 && t.Reported("TimeLeft") && t.TimeLeft < runtime
+}
+
+// sample returns the alert events caused by a successful poll.
+func (a *alerter) sample(t *Target, at time.Time) []Event {
+	var events []Event
+	was := a.alerting()
+	a.failures = 0
+	if lost := t.HasStatus("COMMLOST"); lost != a.commLost {
+		a.commLost = lost
+		if lost {
+			events = append(events, CommunicationsLostEvent{Addr: a.addr, At: at, Target: t})
+		}
+	}
+	if on := t.HasStatus("ONBATT"); on != a.onBattery {
+		a.onBattery = on
+		if on {
+			events = append(events, WentOnBatteryEvent{Addr: a.addr, At: at, Target: t})
+		}
+	}
+	if low := a.isLow(t); low != a.low {
+		a.low = low
+		if low {
+			events = append(events, BatteryLowEvent{Addr: a.addr, At: at, Target: t})
+		}
+	}
+	if was && !a.alerting() {
+		events = append(events, RecoveredEvent{Addr: a.addr, At: at, Target: t})
+	}
+	return events
+}
+
+// failure returns the alert events caused by a failed poll.
+func (a *alerter) failure(err error, at time.Time) []Event {
+	a.failures++
+	if a.commLost || a.failures < max(a.cfg.commLostAfter, 1) {
+		return nil
+	}
+	a.commLost = true
+	return []Event{CommunicationsLostEvent{Addr: a.addr, At: at, Err: err}}
+}
@@ -3,274 +3,1077 @@ package apcupsc
 
 import (
 	"bufio"
-	"encoding/binary"
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"math"
 	"net"
+	"net/netip"
+	"reflect"
+	"slices"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 )
 
 // timeFormat is the output format of apcupsd.
 const timeFormat = "2006-01-02 15:04:05 -0700"
 
-func parseTime(segs []string) (time.Time, error) {
-	return time.Parse(timeFormat, strings.Join(segs, " "))
+// timeFormats are the timestamp formats various apcupsd releases
+// have been known to output, in order of preference.
+var timeFormats = []string{
+	timeFormat,
+	"2006-01-02 15:04:05",
+	"Mon Jan 02 15:04:05 MST 2006",
 }
 
-// TimeLocation is the default location for string formatted timestamps.
+// ErrNotAvailable indicates that apcupsd explicitly reported a value
+// as "N/A".
+var ErrNotAvailable = errors.New("value not available")
+
+// ParseAPCTime parses a timestamp in any of the formats apcupsd
+// releases are known to report, such as "2024-10-19 11:46:30 -0700".
+// Formats that do not carry a numeric zone offset are interpreted in
+// fallbackLoc, or TimeLocation if it is nil, as they are when parsed
+// from a status response. A zone abbreviation, as in "Mon
+// Oct 21 18:55:40 PDT 2024", must be UTC, GMT or one used by
+// fallbackLoc, since an abbreviation alone does not determine an
+// offset; others are an error. Surrounding white space is ignored,
+// and a value of "N/A" returns ErrNotAvailable.
+func ParseAPCTime(s string, fallbackLoc *time.Location) (time.Time, error) {
+	if fallbackLoc == nil {
+		fallbackLoc = TimeLocation
+	}
+	text := strings.TrimSpace(s)
+	if text == "N/A" {
+		return time.Time{}, ErrNotAvailable
+	}
+	var err error
+	for _, f := range timeFormats {
+		var t time.Time
+		if t, err = time.ParseInLocation(f, text, fallbackLoc); err == nil {
+			// The time package gives an abbreviation it cannot
+			// find in fallbackLoc a zero offset.
+			if name, offset := t.Zone(); strings.Contains(f, "MST") && offset == 0 &&
+				t.Location() != fallbackLoc && t.Location() != time.UTC && name != "GMT" {
+				return time.Time{}, fmt.Errorf("unknown time zone %q in %q", name, text)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// dateFormats are the formats apcupsd reports dates, such as
+// BATTDATE, in.
+var dateFormats = []string{
+	"2006-01-02",
+	"01/02/06",
+}
+
+// parseDate parses an apcupsd date as midnight in loc.
+func parseDate(text string, loc *time.Location) (time.Time, error) {
+	text = strings.TrimSpace(text)
+	if text == "N/A" {
+		return time.Time{}, ErrNotAvailable
+	}
+	var err error
+	for _, f := range dateFormats {
+		var t time.Time
+		if t, err = time.ParseInLocation(f, text, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// TimeLocation is the default location for string formatted
+// timestamps. It can be overridden per query with WithLocation.
 var TimeLocation = time.Local
 
 // formatTime outputs a timestamp in the apcupsd format in the
-// preferred time location. This detail allows apcupsd's to each be
-// operating in their own time zone, but represents their values in a
-// common time zone.
-func formatTime(t time.Time) string {
-	return t.In(TimeLocation).Format(timeFormat)
+// preferred time location, loc. This detail allows apcupsd's to each
+// be operating in their own time zone, but represents their values in
+// a common time zone.
+func formatTime(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format(timeFormat)
 }
 
-// Target holds the parsed summary of the APC output.
+// Target holds the parsed summary of the APC output. Any parsed field
+// may be reported by apcupsd as "N/A", in which case it holds its
+// zero value, is not Reported and is NotAvailable.
 type Target struct {
-	// Power consumption in Watts
+	// Power consumption in Watts, derived from NomPower and LoadPct.
+	// Power and Charge are not reported for a UPS on battery whose
+	// LoadPct reads 0, since its firmware has stopped updating it.
 	Power int
-	// Charge in Watt Hours
+	// NomPower is the nominal power rating of the UPS in Watts. Some
+	// models report it as "N/A", see NotAvailable.
+	NomPower int
+	// LoadPct is the load as a percentage (0-100) of NOMPOWER
+	LoadPct float64
+	// Charge in Watt Hours, the energy the battery can deliver at
+	// the present load over TimeLeft, or the share BCharge is of the
+	// capacity set with WithBatteryCapacity
 	Charge int
-	// Backup runtime in minutes
+	// Backup runtime in whole minutes, derived from TimeLeft
 	Backup int
-	// Charged, Offline, fully charged and on battery power
+	// TimeLeft is the full precision backup runtime (TIMELEFT)
+	TimeLeft time.Duration
+	// Charged, Offline, fully charged and on battery power. Charged
+	// is derived from BCharge compared against ChargedThreshold, and
+	// Offline from the ONBATT flag of Status, which need not come
+	// first: a UPS regulating its line power reports "TRIM ONLINE"
+	// or "BOOST ONLINE".
 	Charged, Offline bool
+	// Status holds the STATUS flags, for example "ONLINE" or
+	// "ONBATT LOWBATT", see HasStatus.
+	Status []string
+	// BCharge is the battery charge percentage (0-100)
+	BCharge float64
 	// Name of the UPS
 	Name string
+	// HostName, Version, Mode, Cable and Driver identify the
+	// apcupsd daemon, its release and how the UPS is attached.
+	HostName, Version, Mode, Cable, Driver string
+	// StartTime is when the apcupsd daemon started
+	StartTime time.Time
+	// SampledAt is the daemon's timestamp for the sample (DATE)
+	SampledAt time.Time
 	// LineV is the current line voltage
 	LineV float64
+	// OutputV is the voltage supplied to the load (OUTPUTV), and
+	// OutCurrent the current drawn by it in Amps (OUTCURNT), as
+	// reported by models that measure them.
+	OutputV, OutCurrent float64
+	// LowTransferV (LOTRANS) and HighTransferV (HITRANS) are the
+	// line voltages below and above which the UPS transfers to
+	// battery.
+	LowTransferV, HighTransferV float64
+	// AmbientTemp (AMBTEMP) in degrees Celsius and Humidity
+	// (HUMIDITY) as a percentage are reported by units with an
+	// environmental monitoring probe.
+	AmbientTemp, Humidity float64
+	// ExtBatteries (EXTBATTS) is the number of external battery
+	// packs, and BadBatteries (BADBATTS) how many of them are
+	// defective.
+	ExtBatteries, BadBatteries int
+	// SelfTest is the result of the last battery self test
+	// (SELFTEST), for example "OK", "NO" when none has been run,
+	// "BT" when the battery capacity was insufficient or "NG" when
+	// the test failed.
+	SelfTest string
+	// BattDate is when the battery was installed or last replaced
+	// (BATTDATE).
+	BattDate time.Time
 	// XFers is number of backup transitions
 	XFers int
 	// LastOnBattery time of last being on battery
 	LastOnBattery time.Time
 	// LastOutage is the string version of the outage
+	//
+	// Deprecated: LastOutage is formatted in the location in effect
+	// when the Target was parsed. Use LastOutageString instead.
 	LastOutage string
-	// Lasted is how long the device was on battery
+	// OutageInProgress is true when the UPS went on battery
+	// (XONBATT) more recently than it last returned to line power
+	// (XOFFBATT).
+	OutageInProgress bool
+	// Lasted is how long the device was on battery during the last
+	// completed outage
 	Lasted time.Duration
 	// Duration how long the device was on battery
+	//
+	// Deprecated: Use Lasted.String() instead.
 	Duration string
-}
+	// MinBCharge (MBATTCHG) and MinTimeLeft (MINTIMEL) are the
+	// daemon's shutdown thresholds: apcupsd shuts down its host
+	// when on battery and either BCharge or TimeLeft drops below
+	// them. Zero values mean not reported.
+	MinBCharge  float64
+	MinTimeLeft time.Duration
+	// MaxTime (MAXTIME) is the longest the daemon will run on
+	// battery before shutting down its host. A reported zero means
+	// the limit is disabled.
+	MaxTime time.Duration
+	// AlarmDelay (ALARMDEL) is how long the UPS waits after going
+	// on battery before sounding its alarm. A reported zero means
+	// the alarm is disabled ("No alarm").
+	AlarmDelay time.Duration
+	// DialLatency is how long it took to connect to the apcupsd
+	// service, and QueryLatency is how long the status exchange
+	// took after connecting. Zero values mean not measured.
+	DialLatency, QueryLatency time.Duration
+	// Raw holds every status line received, verbatim and in order,
+	// when requested with WithRaw.
+	Raw []string
+	// Extra maps the keys that are not parsed into other fields to
+	// their values, when requested with WithExtra. The leading "APC"
+	// line, which only counts the lines of the response, is omitted.
+	Extra map[string]string
 
-// dialTimeout attempts to connect to an apcupsd endpoint.
-func dialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
-	opt := net.Dialer{Timeout: timeout}
-	conn, err := opt.Dial("tcp", addr)
-	if err != nil {
-		return nil, err
-	}
-	return conn, nil
+	// reported holds the names of the fields that were reported,
+	// see Reported.
+	reported map[string]bool
+	// na holds the names of the fields reported as "N/A", see
+	// NotAvailable.
+	na map[string]bool
 }
 
-// DialDuration hold the timeout duration for connecting to an apcupsd service.
+// DialDuration hold the default timeout duration for connecting to
+// an apcupsd service. It is read once at the start of each query and
+// can be overridden per query with WithDialTimeout.
 var DialDuration = time.Duration(4 * time.Second)
 
+// ChargedThreshold is the default battery charge percentage at or
+// above which a Target is considered Charged. It can be overridden
+// per query with WithChargedThreshold.
+var ChargedThreshold = 100.0
+
 // ErrIncomplete indicates that the parsed target apcupsd returned
 // truncated output.
 var ErrIncomplete = errors.New("incomplete apcupsd read")
 
+// The stage errors are wrapped, along with the underlying cause, by
+// the errors returned by a failed query, identifying the stage that
+// failed: resolving the hostname of the service, connecting to it,
+// sending the status request, or reading the response. A read that
+// fails also wraps ErrIncomplete, while a response that ends early
+// without a read failure only wraps ErrIncomplete.
+var (
+	ErrResolve = errors.New("resolve failed")
+	ErrDial    = errors.New("dial failed")
+	ErrWrite   = errors.New("write failed")
+	ErrRead    = errors.New("read failed")
+)
+
 // ParseTarget attempts a connection to a target apdupsd address and
 // returns sampled data as a *Target value, or nil when the target is
-// unavailable with the corresponding error.
-func ParseTarget(ep string) (*Target, error) {
-	var nomPower, load float64
-	var backup time.Duration
-	t := &Target{}
+// unavailable with the corresponding error. The opts adjust how the
+// query is performed. ParseTarget is equivalent to Query with a
+// background context.
+func ParseTarget(ep string, opts ...Option) (*Target, error) {
+	return Query(context.Background(), ep, opts...)
+}
 
-	c, err := dialTimeout(ep, DialDuration)
+// Query connects to the apcupsd service at ep and returns its sampled
+// status. The ep is a host:port address, as returned by Endpoint, or
+// a host alone in which case the port defaults to APCUPSDPort (see
+// WithPort). A bare IPv6 address is taken to be a host alone. When the host
+// resolves to several addresses, each is tried in turn. The whole
+// exchange, from dialing to reading the last line of the status, is
+// bounded by ctx and any WithQueryTimeout. When that bound is
+// reached, the returned error wraps context.DeadlineExceeded, or
+// os.ErrDeadlineExceeded.
+func Query(ctx context.Context, ep string, opts ...Option) (*Target, error) {
+	cfg := newConfig(opts)
+	ctx, cancel := cfg.bound(ctx)
+	defer cancel()
+	return cfg.sample(ctx, cfg.addr(ep))
+}
+
+// sample queries the apcupsd service at addr over a new connection,
+// which is closed once the status has been read.
+func (cfg *config) sample(ctx context.Context, addr string) (*Target, error) {
+	n, t, err := cfg.query(ctx, addr)
 	if err != nil {
 		return nil, err
 	}
-	defer c.Close()
+	n.c.Close()
+	return t, nil
+}
 
-	// Tech spec sheets say:
-	// 1500M = 187 WH Battery @ peak 900W - recharge 13W for 16 Hours
-	// 1000M = 140 WH Battery @ peak 600W - recharge 12W for 12 Hours
-	cmdStatus := []byte{0x00, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73}
-	c.Write(cmdStatus)
-	b := bufio.NewReader(c)
-	fullRead := false
-	for {
-		line, _, err := b.ReadLine()
+// minAttempt is the shortest dial timeout given to each of several
+// addresses that a hostname resolves to.
+const minAttempt = time.Second
+
+// resolve returns the host:port addresses to try for addr, one for
+// each address its host resolves to. Hostnames are left for an HTTP
+// proxy to resolve.
+func (cfg *config) resolve(ctx context.Context, addr string) ([]string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := netip.ParseAddr(host); err == nil || cfg.proxy != nil {
+		return []string{addr}, nil
+	}
+	hosts, err := cfg.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrResolve, err)
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("%w: %s has no addresses", ErrResolve, host)
+	}
+	var addrs []string
+	for _, h := range hosts {
+		addrs = append(addrs, net.JoinHostPort(h, port))
+	}
+	return addrs, nil
+}
+
+// query tries each address that addr resolves to in turn, and
+// returns the connection and status of the first to complete a
+// status exchange. When a hostname resolves to several addresses,
+// the dial timeout is shared between them. If every address fails,
+// the error lists the addresses tried.
+func (cfg *config) query(ctx context.Context, addr string) (*nisConn, *Target, error) {
+	addrs, err := cfg.resolve(ctx, addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	timeout := cfg.dialTimeout
+	if len(addrs) > 1 {
+		timeout = max(timeout/time.Duration(len(addrs)), min(timeout, minAttempt))
+	}
+	var errs []error
+	for _, a := range addrs {
+		start := time.Now()
+		c, err := cfg.dial(ctx, a, timeout)
 		if err != nil {
+			errs = append(errs, fmt.Errorf("%w: %w", ErrDial, err))
+			if ctx.Err() != nil {
+				break
+			}
 			continue
 		}
-		unpacked, err := decodeLine(line)
+		connected := time.Now()
+		n := cfg.newConn(c)
+		n.addr = a
+		t, err := cfg.exchange(ctx, n)
 		if err != nil {
+			c.Close()
+			errs = append(errs, err)
+			if ctx.Err() != nil {
+				break
+			}
 			continue
 		}
-		if len(unpacked) < 11 {
+		t.DialLatency = connected.Sub(start)
+		t.QueryLatency = time.Since(connected)
+		return n, t, nil
+	}
+	if len(addrs) == 1 {
+		return nil, nil, errs[0]
+	}
+	return nil, nil, fmt.Errorf("%s: tried %s: %w", addr, strings.Join(addrs[:len(errs)], ", "), errors.Join(errs...))
+}
+
+// nisConn is an established connection to an apcupsd network
+// information server. The same nisConn can be used for successive
+// exchanges.
+type nisConn struct {
+	c net.Conn
+	// addr is the resolved address dialed.
+	addr string
+	b    *bufio.Reader
+	// timeout, when non-zero, bounds each read.
+	timeout time.Duration
+	// ctx and deadline bound the current exchange.
+	ctx      context.Context
+	deadline time.Time
+	// trailer is set while the empty frame ending the previous
+	// response is still unread.
+	trailer bool
+}
+
+// newConn prepares an established connection for exchanges.
+func (cfg *config) newConn(c net.Conn) *nisConn {
+	n := &nisConn{
+		c:       c,
+		timeout: cfg.readTimeout,
+		ctx:     context.Background(),
+	}
+	n.b = bufio.NewReader(n)
+	return n
+}
+
+// Read reads from the connection within the bounds of the current
+// exchange.
+func (n *nisConn) Read(p []byte) (int, error) {
+	if err := n.ctx.Err(); err != nil {
+		return 0, err
+	}
+	deadline := n.deadline
+	if n.timeout > 0 {
+		if d := time.Now().Add(n.timeout); deadline.IsZero() || d.Before(deadline) {
+			deadline = d
+		}
+	}
+	n.c.SetReadDeadline(deadline)
+	return n.c.Read(p)
+}
+
+// exchange requests and parses the status of an apcupsd service over
+// n, bounded by ctx.
+func (cfg *config) exchange(ctx context.Context, n *nisConn) (*Target, error) {
+	n.ctx = ctx
+	n.deadline, _ = ctx.Deadline()
+	n.c.SetWriteDeadline(n.deadline)
+	// Unblock any pending read or write if ctx is cancelled.
+	stop := context.AfterFunc(ctx, func() {
+		n.c.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+	t, err := cfg.status(n)
+	if err != nil {
+		if cerr := ctx.Err(); cerr != nil && !errors.Is(err, cerr) {
+			err = fmt.Errorf("%w: %w", cerr, err)
+		}
+		return nil, err
+	}
+	return t, nil
+}
+
+// status requests and parses the status of an apcupsd service over
+// n.
+func (cfg *config) status(n *nisConn) (*Target, error) {
+	cmdStatus := []byte{0x00, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73}
+	if _, err := n.c.Write(cmdStatus); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrWrite, err)
+	}
+	if cfg.trace != nil {
+		cfg.trace(TraceSend, string(cmdStatus[2:]))
+	}
+	// A previous response on this connection ends with an empty
+	// frame that has not been consumed yet. Otherwise an empty frame
+	// is the whole of an empty response.
+	if n.trailer {
+		n.trailer = false
+		if p, err := n.b.Peek(2); err == nil && p[0] == 0 && p[1] == 0 {
+			n.b.Discard(2)
+		}
+	}
+	t, err := cfg.parse(n.b)
+	n.trailer = err == nil
+	return t, err
+}
+
+// ParseStatus parses the framed apcupsd network protocol response to
+// a "status" command, as read from r. This allows a captured byte
+// stream to be parsed without a network connection. The opts that
+// affect parsing, such as WithLocation, are honored. ParseStatus
+// returns ErrIncomplete if r ends before the "END APC" line.
+func ParseStatus(r io.Reader, opts ...Option) (*Target, error) {
+	return newConfig(opts).parse(bufio.NewReader(r))
+}
+
+// parse parses a framed status response from b.
+func (cfg *config) parse(b *bufio.Reader) (*Target, error) {
+	p := &parser{cfg: cfg, t: &Target{}}
+	for {
+		frame, err := readFrameBuf(b, p.buf)
+		if err == io.EOF {
+			return nil, ErrIncomplete
+		} else if errors.Is(err, ErrTooShort) {
+			return nil, fmt.Errorf("%w: %w", ErrIncomplete, err)
+		} else if err != nil {
+			return nil, fmt.Errorf("%w: %w: %w", ErrIncomplete, ErrRead, err)
+		}
+		if len(frame) == 0 {
+			// The daemon ended its response early.
+			return nil, ErrIncomplete
+		}
+		p.buf = frame[:0]
+		if p.skip(frame) {
 			continue
 		}
-		if strings.HasPrefix(unpacked, "END APC") {
-			fullRead = true
-			break
+		if p.line(string(frame)) {
+			return p.target(), nil
 		}
-		tokens := strings.Split(unpacked[11:], " ")
-		if len(tokens) < 1 {
+	}
+}
+
+// ParseStatusText parses the plain text output of the "apcaccess
+// status" command, as read from r. Each line holds a key and value
+// separated by a colon. Keys may be padded to the usual apcupsd
+// alignment or trimmed, and blank lines are ignored. The opts that
+// affect parsing, such as WithLocation, are honored.
+// ParseStatusText returns ErrIncomplete if r ends before the "END
+// APC" line.
+func ParseStatusText(r io.Reader, opts ...Option) (*Target, error) {
+	p := &parser{cfg: newConfig(opts), t: &Target{}}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		key, value, ok := strings.Cut(s.Text(), ":")
+		if !ok {
 			continue
 		}
-		switch unpacked[:9] {
-		case "NOMPOWER ":
-			if len(tokens) != 2 && tokens[1] != "Watts" {
-				continue
-			}
-			p, err := strconv.Atoi(tokens[0])
-			if err != nil {
-				continue
-			}
-			nomPower = float64(p)
-		case "STATUS   ":
-			t.Offline = tokens[0] != "ONLINE"
-		case "TIMELEFT ":
-			backup, _ = digestDuration(unpacked)
-		case "NUMXFERS ":
-			t.XFers, _ = strconv.Atoi(tokens[0])
-		case "BCHARGE  ":
-			t.Charged = tokens[0] == "100.0"
-		case "LOADPCT  ":
-			if len(tokens) != 2 || tokens[1] != "Percent" {
-				continue
-			}
-			p, _ := strconv.ParseFloat(tokens[0], 64)
-			load = p / 100
-		case "LINEV    ":
-			if len(tokens) != 2 || tokens[1] != "Volts" {
-				continue
-			}
-			t.LineV, _ = strconv.ParseFloat(tokens[0], 64)
-		case "END APC  ":
-		case "UPSNAME  ":
-			t.Name = tokens[0]
-		case "XONBATT  ":
-			t.LastOnBattery, err = parseTime(tokens[0:3])
-			if err != nil {
-				break
-			}
-			t.LastOutage = formatTime(t.LastOnBattery)
-		case "XOFFBATT ":
-			if len(tokens) < 3 {
-				break
-			}
-			when, err := parseTime(tokens[0:3])
-			if err != nil {
-				break
+		if p.line(fmt.Sprintf("%-9s: %s", strings.TrimSpace(key), strings.TrimSpace(value))) {
+			return p.target(), nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return nil, ErrIncomplete
+}
+
+// parser accumulates the fields of a status response.
+type parser struct {
+	cfg *config
+	t   *Target
+	// buf is the scratch buffer frames are read into.
+	buf []byte
+	// offBattery is the XOFFBATT timestamp, which is only meaningful
+	// relative to XONBATT.
+	offBattery time.Time
+}
+
+// skip reports whether the status line in frame would be ignored by
+// line, and so need not be converted to a string.
+func (p *parser) skip(frame []byte) bool {
+	if p.cfg.raw || p.cfg.trace != nil || p.cfg.extra || p.cfg.lineHook != nil {
+		return false
+	}
+	if len(frame) < 11 {
+		return true
+	}
+	return parsedKeys[string(frame[:9])] == nil && !bytes.HasPrefix(frame, []byte("END APC"))
+}
+
+// line digests a single decoded status line of the form "KEY      :
+// value". It returns true once the final "END APC" line is seen.
+func (p *parser) line(unpacked string) bool {
+	if p.cfg.trace != nil {
+		p.cfg.trace(TraceRecv, unpacked)
+	}
+	if p.cfg.raw {
+		p.t.Raw = append(p.t.Raw, unpacked)
+		p.t.report("Raw")
+	}
+	if strings.HasPrefix(unpacked, "END APC") {
+		return len(unpacked) >= 11
+	}
+	if p.cfg.lineHook != nil || p.cfg.extra {
+		if key, value, ok := strings.Cut(unpacked, ":"); ok {
+			key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+			if p.cfg.lineHook != nil && p.cfg.lineHook(key, value) {
+				return false
 			}
-			d := when.Sub(t.LastOnBattery)
-			if d <= 0 {
-				break
+			// The leading "APC" line is framing, not a status key.
+			if p.cfg.extra && key != "APC" && (len(unpacked) < 11 || parsedKeys[unpacked[:9]] == nil) {
+				if p.t.Extra == nil {
+					p.t.Extra = make(map[string]string)
+				}
+				p.t.Extra[key] = value
+				p.t.report("Extra")
 			}
-			t.Lasted = d
-			t.Duration = d.String()
-		default:
+		}
+	}
+	if len(unpacked) < 11 {
+		return false
+	}
+	k := parsedKeys[unpacked[:9]]
+	if k == nil {
+		return false
+	}
+	value := unpacked[11:]
+	if strings.TrimSpace(value) == "N/A" {
+		p.t.unavailable(k.Field)
+		return false
+	}
+	if _, unit, _ := strings.Cut(value, " "); k.Unit != "" && !k.anyUnit && unit != k.Unit {
+		return false
+	}
+	if err := k.digest(p, value); err != nil || k.deferred {
+		return false
+	}
+	p.t.report(k.Field)
+	p.t.report(k.also...)
+	return false
+}
+
+// target completes the derived fields of a fully parsed Target.
+func (p *parser) target() *Target {
+	// Tech spec sheets say:
+	// 1500M = 187 WH Battery @ peak 900W - recharge 13W for 16 Hours
+	// 1000M = 140 WH Battery @ peak 600W - recharge 12W for 12 Hours
+	t := p.t
+	nomPower, load := float64(t.NomPower), t.LoadPct/100
+	// On battery, some firmware stops updating LOADPCT and reports
+	// 0, which cannot be true of a UPS that is still running.
+	// Deriving Power and Charge from it would report the unit as
+	// idle and empty, so they are left unreported instead.
+	frozen := t.Offline && t.LoadPct == 0 && t.TimeLeft > 0
+	if t.Reported("NomPower") && t.Reported("LoadPct") && !frozen {
+		t.Power = int(math.Round(nomPower * load))
+		t.report("Power")
+		if t.Reported("TimeLeft") && p.cfg.capacity == 0 {
+			t.Charge = int(nomPower * load * t.TimeLeft.Hours())
+			t.report("Charge")
+		}
+	}
+	// With a known capacity the stored energy follows BCHARGE, which
+	// unlike LOADPCT keeps tracking the battery during an outage.
+	if p.cfg.capacity > 0 && t.Reported("BCharge") {
+		t.Charge = int(p.cfg.capacity * t.BCharge / 100)
+		t.report("Charge")
+	}
+	t.Backup = int(t.TimeLeft / time.Minute)
+	p.outage()
+	return t
+}
+
+// outage relates the XONBATT and XOFFBATT timestamps, which need not
+// describe the same event: during an outage XOFFBATT is "N/A" or
+// still marks the end of the previous outage, and after a daemon
+// restart XONBATT can be "N/A" while XOFFBATT is set. Lasted is only
+// computed for a completed outage of plausible duration.
+func (p *parser) outage() {
+	t := p.t
+	if t.LastOnBattery.IsZero() {
+		return
+	}
+	t.report("OutageInProgress")
+	if !p.offBattery.After(t.LastOnBattery) {
+		t.OutageInProgress = true
+		return
+	}
+	d := p.offBattery.Sub(t.LastOnBattery)
+	if d > p.cfg.maxRuntime {
+		return
+	}
+	t.Lasted = d
+	t.Duration = d.String()
+	t.report("Lasted", "Duration")
+}
+
+// parseStatusFlags splits the value of a STATUS line into its flags.
+func parseStatusFlags(value string) []string {
+	var flags []string
+	for _, f := range strings.Fields(value) {
+		if f == "DOWN" && len(flags) > 0 && flags[len(flags)-1] == "SHUTTING" {
+			flags[len(flags)-1] = "SHUTTING DOWN"
 			continue
 		}
+		flags = append(flags, f)
 	}
+	return flags
+}
 
-	if !fullRead {
-		return nil, ErrIncomplete
+// HasStatus reports whether the STATUS of t includes flag. The flags
+// apcupsd reports include "ONLINE", "ONBATT", "LOWBATT",
+// "REPLACEBATT", "COMMLOST", "OVERLOAD", "CAL", "TRIM", "BOOST",
+// "NOBATT" and "SHUTTING DOWN".
+func (t *Target) HasStatus(flag string) bool {
+	for _, f := range t.Status {
+		if f == flag {
+			return true
+		}
 	}
+	return false
+}
 
-	t.Power = int(nomPower * load)
-	mins := float64(backup / time.Minute)
-	t.Charge = int(nomPower * load * mins / 60)
-	t.Backup = int(mins)
+// report records that the named fields of t were reported by the
+// apcupsd service.
+func (t *Target) report(fields ...string) {
+	if t.reported == nil {
+		t.reported = make(map[string]bool, len(parsedKeys))
+	}
+	for _, f := range fields {
+		t.reported[f] = true
+	}
+}
 
-	return t, nil
+// unavailable records that field was reported as "N/A".
+func (t *Target) unavailable(field string) {
+	if t.na == nil {
+		t.na = make(map[string]bool)
+	}
+	t.na[field] = true
+}
+
+// NotAvailable reports whether apcupsd reported the named Target
+// field, for example "NomPower", as "N/A", that is the UPS or daemon
+// has no value for it. Such a field is not Reported and holds its zero
+// value. Together with Reported this distinguishes three cases: a
+// reported value, which may legitimately be zero, a value explicitly
+// not available, and a key absent from the response. Lasted is not
+// available when XOFFBATT is "N/A".
+func (t *Target) NotAvailable(field string) bool {
+	return t.na[field]
+}
+
+// Reported reports whether the named Target field, for example
+// "LineV", was reported by the apcupsd service. This distinguishes a
+// zero value from a value the UPS does not report. Derived fields,
+// such as Power, are reported when all of their inputs are. A Target
+// that was not produced by parsing an apcupsd status, such as one
+// constructed by the caller, reports every field.
+func (t *Target) Reported(field string) bool {
+	if t.reported == nil {
+		return true
+	}
+	return t.reported[field]
+}
+
+// clone returns a copy of t that shares no memory with it, or nil
+// for a nil t.
+func (t *Target) clone() *Target {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	c.Status = slices.Clone(t.Status)
+	c.Raw = slices.Clone(t.Raw)
+	c.Extra = maps.Clone(t.Extra)
+	c.reported = maps.Clone(t.reported)
+	c.na = maps.Clone(t.na)
+	return &c
+}
+
+// alwaysReported lists the Target fields that are not subject to
+// presence tracking.
+var alwaysReported = map[string]bool{
+	"DialLatency":  true,
+	"QueryLatency": true,
+}
+
+// deprecatedFields lists the Target fields that are only retained
+// for compatibility, and are omitted from encodings of Targets.
+var deprecatedFields = map[string]bool{
+	"LastOutage": true,
+	"Duration":   true,
+}
+
+// LastOutageString formats LastOnBattery in the apcupsd timestamp
+// format in loc, or in TimeLocation when loc is nil. It returns an
+// empty string when there has been no outage.
+func (t *Target) LastOutageString(loc *time.Location) string {
+	if t.LastOnBattery.IsZero() {
+		return ""
+	}
+	if loc == nil {
+		loc = TimeLocation
+	}
+	return formatTime(t.LastOnBattery, loc)
+}
+
+// MarshalJSON encodes t as a JSON object keyed by field name,
+// omitting the fields that were not Reported and the deprecated
+// fields. Raw is only included when it holds lines.
+func (t *Target) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	v := reflect.ValueOf(t).Elem()
+	for _, f := range reflect.VisibleFields(v.Type()) {
+		if !f.IsExported() || deprecatedFields[f.Name] || (!alwaysReported[f.Name] && !t.Reported(f.Name)) {
+			continue
+		}
+		if f.Name == "Raw" && len(t.Raw) == 0 {
+			continue
+		}
+		b, err := json.Marshal(v.FieldByIndex(f.Index).Interface())
+		if err != nil {
+			return nil, err
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, "%q:", f.Name)
+		buf.Write(b)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Stale reports whether the daemon's timestamp for the sample,
+// SampledAt, is more than maxAge older than now. apcupsd continues
+// to serve its last reading after losing contact with the UPS, so a
+// stale sample suggests the values no longer reflect the UPS. The
+// age is measured against the daemon's clock, so any skew between
+// the client and daemon clocks is the caller's concern. A sample
+// without a timestamp, or one that appears to be from the future, is
+// not reported as stale.
+func (t *Target) Stale(maxAge time.Duration, now time.Time) bool {
+	if t.SampledAt.IsZero() {
+		return false
+	}
+	return now.Sub(t.SampledAt) > maxAge
+}
+
+// ApparentPowerVA returns the apparent power supplied to the load in
+// Volt-Amps, the product of OutputV and OutCurrent. It returns 0 when
+// the UPS does not report both.
+func (t *Target) ApparentPowerVA() float64 {
+	if !t.Reported("OutputV") || !t.Reported("OutCurrent") {
+		return 0
+	}
+	return t.OutputV * t.OutCurrent
+}
+
+// TransferMargin returns how many Volts LineV is above LowTransferV
+// and below HighTransferV, that is how far the line voltage can fall
+// or rise before the UPS transfers to battery. A margin is NaN when
+// LineV or the corresponding threshold was not reported.
+func (t *Target) TransferMargin() (low, high float64) {
+	low, high = math.NaN(), math.NaN()
+	if !t.Reported("LineV") {
+		return
+	}
+	if t.Reported("LowTransferV") && t.LowTransferV > 0 {
+		low = t.LineV - t.LowTransferV
+	}
+	if t.Reported("HighTransferV") && t.HighTransferV > 0 {
+		high = t.HighTransferV - t.LineV
+	}
+	return
+}
+
+// DefaultClockSkew is a suitable allowance for Fresh to make for the
+// daemon's clock running behind the client's.
+const DefaultClockSkew = 5 * time.Second
+
+// Fresh reports whether t plausibly reflects the current state of the
+// UPS. It returns false when apcupsd flags that it has lost contact
+// with the UPS (COMMLOST), or when the sample is Stale by more than
+// maxAge, plus the skew allowed for the daemon's clock, relative to
+// the client's clock: older daemons continue to serve their last
+// values without the flag. A maxAge of zero disables the age check.
+func (t *Target) Fresh(maxAge, skew time.Duration) bool {
+	return t.freshAt(maxAge, skew, time.Now())
+}
+
+// freshAt is Fresh relative to now rather than the client's clock.
+func (t *Target) freshAt(maxAge, skew time.Duration, now time.Time) bool {
+	if t.HasStatus("COMMLOST") {
+		return false
+	}
+	return maxAge <= 0 || !t.Stale(maxAge+skew, now)
 }
 
 // ErrTooShort indicates that an apcupsd string return was too short
 // to encode a string.
 var ErrTooShort = errors.New("returned string too short")
 
-// decodeLine decodes the apcupsd line encoding to return a string value.
-func decodeLine(b []byte) (string, error) {
-	if len(b) < 2 {
-		return "", ErrTooShort
+// readFrame reads a single frame of the apcupsd line encoding, a
+// two byte big-endian length followed by that many bytes, and returns
+// its value without the trailing newline. Frames are read in full
+// regardless of the size of b's buffer. An empty frame marks the end
+// of a response. The error is io.EOF only if no part of a frame was
+// read.
+func readFrame(b *bufio.Reader) (string, error) {
+	frame, err := readFrameBuf(b, nil)
+	return string(frame), err
+}
+
+// readFrameBuf is readFrame, but returns the frame in buf, which is
+// grown as needed, to avoid allocating for each frame. The returned
+// slice is only valid until buf is next used.
+func readFrameBuf(b *bufio.Reader, buf []byte) ([]byte, error) {
+	if cap(buf) < 2 {
+		buf = make([]byte, 2, 256)
+	}
+	hdr := buf[:2]
+	if _, err := io.ReadFull(b, hdr); err == io.ErrUnexpectedEOF {
+		return nil, ErrTooShort
+	} else if err != nil {
+		return nil, err
+	}
+	n := int(hdr[0])<<8 | int(hdr[1])
+	if cap(buf) < n {
+		buf = make([]byte, n)
 	}
-	length := int(uint(b[0])*256 + uint(b[1]))
-	if length != len(b)-1 {
-		return "", fmt.Errorf("expected %d got %d:%q", length, len(b), b[2:])
+	frame := buf[:n]
+	if _, err := io.ReadFull(b, frame); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("%w: expected %d bytes", ErrTooShort, n)
+	} else if err != nil {
+		return nil, err
 	}
-	return string(b[2:]), nil
+	return bytes.TrimSuffix(frame, []byte("\n")), nil
 }
 
-// digestDuration consumes a string and converts it to a time.Duration.
-func digestDuration(text string) (time.Duration, error) {
-	if len(text) < 11 {
-		return 0, fmt.Errorf("too short %d", len(text))
-	}
-	tokens := strings.Split(text[11:], " ")
-	if len(tokens) != 2 {
-		return 0, fmt.Errorf("want 2, got %d", len(tokens))
+// parseNumber parses a decimal number as reported by apcupsd. Some
+// localized builds write a comma as the decimal separator, as in
+// "231,5", and may also group thousands with the other separator, as
+// in "1.234,5" or "1,234.5". When both separators appear the last is
+// taken as the decimal separator, and a single comma alone is a
+// decimal separator: "1,234" is read as 1.234, not 1234. Only values
+// that are whole by nature are read with grouped thousands alone,
+// see parseInteger.
+func parseNumber(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err == nil || !strings.ContainsRune(s, ',') {
+		return f, err
 	}
-	factor := 0.0
-	switch strings.ToLower(tokens[1]) {
-	case "minutes":
-		factor = 60
-	case "seconds":
-		factor = 1
+	dot, comma := strings.LastIndexByte(s, '.'), strings.LastIndexByte(s, ',')
+	switch {
+	case dot > comma:
+		s = strings.ReplaceAll(s, ",", "")
+	case dot >= 0 || strings.Count(s, ",") == 1:
+		s = strings.ReplaceAll(s, ".", "")
+		s = strings.Replace(s, ",", ".", 1)
+		if strings.Contains(s, ",") {
+			return 0, err
+		}
 	default:
-		return 0, fmt.Errorf("unrecognized time metric %q", tokens[1])
+		s = strings.ReplaceAll(s, ",", "")
 	}
-	f, err := strconv.ParseFloat(tokens[0], 64)
-	if err != nil {
+	if g, gerr := strconv.ParseFloat(s, 64); gerr == nil {
+		return g, nil
+	}
+	return 0, err
+}
+
+// parseInteger parses a whole number as reported by apcupsd, such as
+// NOMPOWER or NUMXFERS. Since the value cannot have a fraction, its
+// thousands may be grouped by either separator, as in "1.500" or
+// "1,500", both of which are read as 1500. Other values are parsed
+// as by parseNumber, and must be whole.
+func parseInteger(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err == nil {
+		return n, nil
+	}
+	if grouped(s) {
+		return strconv.Atoi(strings.NewReplacer(",", "", ".", "").Replace(s))
+	}
+	f, ferr := parseNumber(s)
+	if ferr != nil {
+		return 0, ferr
+	}
+	if f != math.Trunc(f) || math.Abs(f) >= math.MaxInt64 {
 		return 0, err
 	}
-	return time.Second * time.Duration(factor*f), nil
+	return int(f), nil
 }
 
-// APCUPSDPort is the numerical port value for the apcupsd service.
-var APCUPSDPort = 3551
+// parseNumber parses a decimal value as parseNumber does or, under
+// WithStrictNumbers, only as a plain decimal with a point.
+func (p *parser) parseNumber(s string) (float64, error) {
+	if p.cfg.strictNumbers {
+		return strconv.ParseFloat(s, 64)
+	}
+	return parseNumber(s)
+}
 
-// Scan scans a network for apcupsd services. The network string is
-// provided in the format expected by net.ParseCIDR(). Scan returns a
-// slice of full port addresses found. This function currently only
-// support IPv4 networks.
-func Scan(network string, timeout time.Duration) (ans []string) {
-	_, nInfo, err := net.ParseCIDR(network)
-	if err != nil || len(nInfo.Mask) != 4 {
-		return
+// parseInteger parses a whole value as parseInteger does or, under
+// WithStrictNumbers, only as a plain decimal that is whole.
+func (p *parser) parseInteger(s string) (int, error) {
+	if !p.cfg.strictNumbers {
+		return parseInteger(s)
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
 	}
+	if f != math.Trunc(f) || math.Abs(f) >= math.MaxInt64 {
+		return 0, fmt.Errorf("%q is not a whole number", s)
+	}
+	return int(f), nil
+}
 
-	mask := binary.BigEndian.Uint32(nInfo.Mask)
-	first := binary.BigEndian.Uint32(nInfo.IP)
-	last := (first & mask) | ^mask
+// grouped reports whether s is a whole number whose digits are
+// grouped in thousands by a single kind of separator, as in "1.500"
+// or "12,345,678".
+func grouped(s string) bool {
+	s = strings.TrimPrefix(s, "-")
+	sep := strings.IndexAny(s, ".,")
+	if sep < 1 || sep > 3 {
+		return false
+	}
+	groups := strings.Split(s[sep+1:], s[sep:sep+1])
+	for _, g := range append(groups, s[:sep]) {
+		if g == "" || strings.Trim(g, "0123456789") != "" {
+			return false
+		}
+	}
+	for _, g := range groups {
+		if len(g) != 3 {
+			return false
+		}
+	}
+	return true
+}
 
-	var wg0 sync.WaitGroup
-	var wg sync.WaitGroup
-	ch := make(chan string)
-	wg0.Add(1)
-	go func() {
-		defer wg0.Done()
-		for r := range ch {
-			ans = append(ans, r)
+// durationUnits are the units of time apcupsd reports durations in.
+var durationUnits = []struct {
+	name    string
+	seconds float64
+}{
+	{"day", 24 * 60 * 60},
+	{"hour", 60 * 60},
+	{"minute", 60},
+	{"second", 1},
+}
+
+// digestDuration converts the value of a status line, such as the
+// "103.2 Minutes" of "TIMELEFT : 103.2 Minutes", to a time.Duration.
+// The value and unit may be separated by any amount of white space.
+func digestDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	num, unit := value, ""
+	if i := strings.IndexAny(value, " \t"); i >= 0 {
+		num, unit = value[:i], strings.TrimSpace(value[i:])
+	}
+	if num == "" || unit == "" || strings.ContainsAny(unit, " \t") {
+		return 0, fmt.Errorf("want 2, got %d", len(strings.Fields(value)))
+	}
+	return ParseDuration(num, unit)
+}
+
+// ParseDuration converts a duration reported by apcupsd as a decimal
+// value and its unit, for example "103.2" and "Minutes", to a
+// time.Duration. The recognized units are seconds, minutes, hours
+// and days, in either singular or plural form and any letter case.
+// A comma is accepted as the decimal separator, as some localized
+// builds write it: a lone comma, as in "1,234", is read as the
+// decimal 1.234.
+// A value of "N/A" returns ErrNotAvailable. Values that are not
+// finite or do not fit a time.Duration are rejected.
+func ParseDuration(value, unit string) (time.Duration, error) {
+	value, unit = strings.TrimSpace(value), strings.TrimSpace(unit)
+	if value == "N/A" {
+		return 0, ErrNotAvailable
+	}
+	factor := 0.0
+	for _, m := range durationUnits {
+		if strings.EqualFold(unit, m.name) || strings.EqualFold(unit, m.name+"s") {
+			factor = m.seconds
+			break
 		}
-	}()
-	for n := first + 1; n <= last; n++ {
-		var target string
-		ip := make([]byte, 4)
-		binary.BigEndian.PutUint32(ip, n)
-		target = net.IP(ip).String()
-		target = fmt.Sprint(target, ":", APCUPSDPort)
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			c, err := dialTimeout(target, timeout)
-			if err != nil {
-				return
-			}
-			defer c.Close()
-			ch <- target
-		}()
 	}
-	wg.Wait()
-	close(ch)
-	wg0.Wait()
-	return
+	if factor == 0 {
+		return 0, fmt.Errorf("unrecognized time metric %q", unit)
+	}
+	f, err := parseNumber(value)
+	if err != nil {
+		return 0, err
+	}
+	d := factor * f * float64(time.Second)
+	if math.IsNaN(d) || math.Abs(d) >= math.MaxInt64 {
+		return 0, fmt.Errorf("duration %s %s out of range", value, unit)
+	}
+	return time.Duration(math.Round(d)), nil
+}
+
+// APCUPSDPort is the numerical port value for the apcupsd service.
+var APCUPSDPort = 3551
+
+// Endpoint returns the host:port address of an apcupsd service. The
+// host may be a hostname, an IPv4 address, or an IPv6 address with or
+// without enclosing brackets; IPv6 addresses are always bracketed in
+// the result, as in "[fd00:1::10]:3551". This is the address format
+// accepted by Query and returned by Scan.
+//
+// The address is normalized: IP addresses are written in their
+// canonical form and hostnames in lower case. Everything in this
+// package that identifies a UPS, such as the Addr of a Client,
+// Result, ScanResult, Event or PollStatus, the key passed to sinks
+// and the keys of a Cache, uses this normalized address, so several
+// daemons on one host are distinguished by their ports and one
+// daemon is never counted twice under different spellings.
+func Endpoint(host string, port int) string {
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if ip, err := netip.ParseAddr(host); err == nil {
+		host = ip.String()
+	} else {
+		host = strings.ToLower(host)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
 }
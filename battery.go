@@ -0,0 +1,31 @@
+package apcupsc
+
+import (
+	"fmt"
+	"time"
+)
+
+// BatteryAdvisory combines the signals apcupsd reports that suggest
+// the battery needs replacing: the REPLACEBATT status flag, a failed
+// SelfTest, and a BattDate more than maxAge before now. Each signal
+// only contributes when it was reported, and a maxAge of zero
+// disables the age check. The reasons explain each signal found.
+func (t *Target) BatteryAdvisory(now time.Time, maxAge time.Duration) (needsReplacement bool, reasons []string) {
+	if t.HasStatus("REPLACEBATT") {
+		reasons = append(reasons, "UPS reports REPLACEBATT")
+	}
+	if t.Reported("SelfTest") {
+		switch t.SelfTest {
+		case "BT":
+			reasons = append(reasons, "self test found insufficient battery capacity")
+		case "NG":
+			reasons = append(reasons, "self test failed")
+		}
+	}
+	if maxAge > 0 && t.Reported("BattDate") && !t.BattDate.IsZero() {
+		if age := now.Sub(t.BattDate); age > maxAge {
+			reasons = append(reasons, fmt.Sprintf("battery installed %s, more than %v ago", t.BattDate.Format(dateFormats[0]), maxAge))
+		}
+	}
+	return len(reasons) != 0, reasons
+}
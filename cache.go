@@ -0,0 +1,164 @@
+package apcupsc
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// CacheErrorTTL is the longest time a Cache will remember a failed
+// query before retrying it.
+var CacheErrorTTL = 5 * time.Second
+
+// flight is an in progress query shared by concurrent callers.
+type flight struct {
+	done chan struct{}
+	t    *Target
+	err  error
+	// waiters counts the callers still waiting for the result, and
+	// cancel abandons the query once none are.
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// flightGroup coalesces concurrent queries of the same key into a
+// single call.
+type flightGroup struct {
+	mu sync.Mutex
+	m  map[string]*flight
+}
+
+// do calls fn for key unless a call for key is already in progress,
+// in which case it waits for and shares that call's result. Each
+// caller receives its own copy of the Target, and stops waiting when
+// its own ctx is done. The ctx passed to fn is only cancelled once
+// every caller has stopped waiting, so one caller giving up does not
+// fail the others.
+func (g *flightGroup) do(ctx context.Context, key string, fn func(ctx context.Context) (*Target, error)) (*Target, error) {
+	g.mu.Lock()
+	f, ok := g.m[key]
+	if !ok {
+		if g.m == nil {
+			g.m = make(map[string]*flight)
+		}
+		fctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		f = &flight{done: make(chan struct{}), cancel: cancel}
+		g.m[key] = f
+		go func() {
+			defer cancel()
+			f.t, f.err = fn(fctx)
+			g.mu.Lock()
+			g.forget(key, f)
+			g.mu.Unlock()
+			close(f.done)
+		}()
+	}
+	f.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.t.clone(), f.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		if f.waiters--; f.waiters == 0 {
+			g.forget(key, f)
+			f.cancel()
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// forget removes f as the flight in progress for key, unless a newer
+// flight has replaced it. The caller must hold g.mu.
+func (g *flightGroup) forget(key string, f *flight) {
+	if g.m[key] == f {
+		delete(g.m, key)
+	}
+}
+
+// cacheEntry holds the remembered result of a query.
+type cacheEntry struct {
+	t       *Target
+	err     error
+	expires time.Time
+}
+
+// Cache remembers recent query results so that frequent callers do
+// not each query an apcupsd service. It is safe for concurrent use.
+type Cache struct {
+	ttl     time.Duration
+	opts    []Option
+	flights flightGroup
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+// NewCache returns a Cache that remembers successful query results
+// for ttl, and failed ones for the shorter of ttl and CacheErrorTTL.
+// The opts are used for every query made by the Cache.
+func NewCache(ttl time.Duration, opts ...Option) *Cache {
+	return &Cache{
+		ttl:     ttl,
+		opts:    opts,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+// lookup returns the unexpired entry for addr, if any, forgetting
+// it if it has expired.
+func (c *Cache) lookup(addr string) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[addr]
+	if !ok {
+		return cacheEntry{}, false
+	}
+	if !time.Now().Before(e.expires) {
+		delete(c.entries, addr)
+		return cacheEntry{}, false
+	}
+	return e, true
+}
+
+// store remembers the result of querying addr, and forgets every
+// expired entry, so that addresses no longer asked for do not
+// accumulate.
+func (c *Cache) store(addr string, e cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for a, old := range c.entries {
+		if !now.Before(old.expires) {
+			delete(c.entries, a)
+		}
+	}
+	c.entries[addr] = e
+}
+
+// Get returns the status of the apcupsd service at ep, querying it
+// only if no result younger than the Cache's ttl is remembered.
+// Concurrent calls for the same endpoint share a single query. Each
+// caller receives its own copy of the Target, which it may modify.
+func (c *Cache) Get(ep string) (*Target, error) {
+	addr := newConfig(c.opts).addr(ep)
+	if e, ok := c.lookup(addr); ok {
+		return e.t.clone(), e.err
+	}
+	return c.flights.do(context.Background(), addr, func(ctx context.Context) (*Target, error) {
+		// A query may have completed while this one was waiting
+		// to start.
+		if e, ok := c.lookup(addr); ok {
+			return e.t, e.err
+		}
+		t, err := endpoint{addr: addr, opts: c.opts}.Status(ctx)
+		ttl := c.ttl
+		if err != nil {
+			ttl = min(ttl, CacheErrorTTL)
+		}
+		c.store(addr, cacheEntry{t: t, err: err, expires: time.Now().Add(ttl)})
+		return t, err
+	})
+}
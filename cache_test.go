@@ -0,0 +1,185 @@
+package apcupsc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waiting returns the number of callers waiting for the flight in
+// progress for key.
+func (g *flightGroup) waiting(key string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if f := g.m[key]; f != nil {
+		return f.waiters
+	}
+	return 0
+}
+
+func TestFlightCallerContexts(t *testing.T) {
+	var g flightGroup
+	release := make(chan struct{})
+	fnDone := make(chan error, 1)
+	fn := func(ctx context.Context) (*Target, error) {
+		select {
+		case <-release:
+			return &Target{Name: "shared"}, nil
+		case <-ctx.Done():
+			fnDone <- ctx.Err()
+			return nil, ctx.Err()
+		}
+	}
+
+	first, cancelFirst := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := g.do(first, "k", fn)
+		firstErr <- err
+	}()
+	waitFor(t, "first caller", func() bool { return g.waiting("k") == 1 })
+	second := make(chan *Target, 1)
+	go func() {
+		got, err := g.do(context.Background(), "k", fn)
+		if err != nil {
+			t.Errorf("second caller: %v", err)
+		}
+		second <- got
+	}()
+	waitFor(t, "second caller", func() bool { return g.waiting("k") == 2 })
+
+	// The caller that started the query giving up must not cancel
+	// it for the other.
+	cancelFirst()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("first caller: got %v, want context.Canceled", err)
+	}
+	close(release)
+	if got := <-second; got == nil || got.Name != "shared" {
+		t.Errorf("second caller: got %+v", got)
+	}
+
+	// Once every caller has given up, the query is cancelled.
+	abandoned, cancel := context.WithCancel(context.Background())
+	release = make(chan struct{})
+	go func() {
+		g.do(abandoned, "k", fn)
+	}()
+	waitFor(t, "abandoned caller", func() bool { return g.waiting("k") == 1 })
+	cancel()
+	if err := <-fnDone; !errors.Is(err, context.Canceled) {
+		t.Errorf("abandoned query: got %v, want context.Canceled", err)
+	}
+}
+
+func TestCacheGetCoalesces(t *testing.T) {
+	const n = 8
+	c := NewCache(time.Minute)
+	var addr string
+	addr, conns := startNIS(t, func(int) []byte {
+		// Hold the response until every Get is waiting for it.
+		for deadline := time.Now().Add(5 * time.Second); c.flights.waiting(addr) < n && time.Now().Before(deadline); {
+			time.Sleep(time.Millisecond)
+		}
+		return frameLines(testDump)
+	})
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := c.Get(addr)
+			if err == nil && got.Name != "myapc" {
+				err = fmt.Errorf("got Name %q", got.Name)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Get: %v", err)
+		}
+	}
+	if _, err := c.Get(addr); err != nil {
+		t.Errorf("cached Get: %v", err)
+	}
+	if got := conns.Load(); got != 1 {
+		t.Errorf("server saw %d connections, want 1", got)
+	}
+}
+
+func TestCacheRemembersErrors(t *testing.T) {
+	addr, conns := startNIS(t, func(int) []byte { return nil })
+	c := NewCache(time.Minute, WithQueryTimeout(time.Second))
+	for i := range 3 {
+		if _, err := c.Get(addr); !errors.Is(err, ErrIncomplete) {
+			t.Errorf("Get %d: got %v, want ErrIncomplete", i, err)
+		}
+	}
+	if got := conns.Load(); got != 1 {
+		t.Errorf("server saw %d connections, want the failure remembered after 1", got)
+	}
+}
+
+func TestCacheExpires(t *testing.T) {
+	addr, conns := startNIS(t, answer(testDump))
+	c := NewCache(time.Millisecond)
+	for i := range 2 {
+		if _, err := c.Get(addr); err != nil {
+			t.Fatalf("Get %d: %v", i, err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	if got := conns.Load(); got != 2 {
+		t.Errorf("server saw %d connections, want 2 once the first result expired", got)
+	}
+}
+
+func TestCacheHitsAreCopies(t *testing.T) {
+	addr, conns := startNIS(t, answer(testDump))
+	c := NewCache(time.Minute)
+	first, err := c.Get(addr)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	first.Name = "changed"
+	first.Status[0] = "ONBATT"
+	hit, err := c.Get(addr)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if hit.Name != "myapc" || hit.Status[0] != "ONLINE" {
+		t.Errorf("changing a result changed the cache: Name %q, Status %v", hit.Name, hit.Status)
+	}
+	hit.Name = "changed again"
+	if again, _ := c.Get(addr); again.Name != "myapc" {
+		t.Errorf("changing a cache hit changed the cache: Name %q", again.Name)
+	}
+	if got := conns.Load(); got != 1 {
+		t.Errorf("server saw %d connections, want 1", got)
+	}
+}
+
+func TestCacheEvicts(t *testing.T) {
+	gone, _ := startNIS(t, answer(testDump))
+	kept, _ := startNIS(t, answer(testDump))
+	c := NewCache(time.Millisecond)
+	if _, err := c.Get(gone); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if _, err := c.Get(kept); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[gone]; ok || len(c.entries) != 1 {
+		t.Errorf("entries %v, want the expired %s evicted", c.entries, gone)
+	}
+}
@@ -0,0 +1,117 @@
+package apcupsc
+
+import (
+	"fmt"
+	"time"
+)
+
+// ChargeState is the battery charging state tracked by a
+// ChargeTracker.
+type ChargeState int
+
+// The ChargeState values. ChargeUnknown is the state of a
+// ChargeTracker before its first usable sample.
+const (
+	ChargeUnknown ChargeState = iota
+	Charging
+	Charged
+	Discharging
+)
+
+// String returns the name of a ChargeState.
+func (s ChargeState) String() string {
+	switch s {
+	case ChargeUnknown:
+		return "Unknown"
+	case Charging:
+		return "Charging"
+	case Charged:
+		return "Charged"
+	case Discharging:
+		return "Discharging"
+	default:
+		return fmt.Sprintf("ChargeState(%d)", int(s))
+	}
+}
+
+// ChargeTracker follows the charging state of a single UPS across
+// samples. Unlike Target.Charged, which compares each sample against
+// a single threshold and so flaps when BCharge hovers around it, a
+// ChargeTracker only enters Charged once BCharge reaches enter, and
+// only leaves it when BCharge drops below exit or the UPS goes on
+// battery. Below the thresholds the trend of BCharge tells Charging
+// from Discharging. A ChargeTracker is not safe for concurrent use.
+type ChargeTracker struct {
+	enter, exit float64
+	state       ChargeState
+	// last is the previous BCharge reported, if any.
+	last     float64
+	haveLast bool
+	// falling is true when the state is Discharging because BCharge
+	// fell on line power.
+	falling bool
+}
+
+// NewChargeTracker returns a ChargeTracker that enters Charged at a
+// BCharge of at least enter percent, and leaves it below exit
+// percent. An exit above enter is treated as equal to enter.
+func NewChargeTracker(enter, exit float64) *ChargeTracker {
+	return &ChargeTracker{enter: enter, exit: min(exit, enter)}
+}
+
+// State returns the current state.
+func (c *ChargeTracker) State() ChargeState {
+	return c.state
+}
+
+// Update folds the sample t into the tracked state, returning the
+// new state and whether it changed. A UPS on battery is Discharging.
+// On line power, it is Charged depending on BCharge and the
+// thresholds, and otherwise Discharging while BCharge falls, as
+// during a self test or calibration, or Charging. A BCharge that
+// holds steady keeps a UPS Discharging on line power, since apcupsd
+// reports it coarsely. A nil t, as for a failed poll, or one that
+// reports neither STATUS nor BCHARGE leaves the state unchanged, so
+// missed samples do not cause transitions.
+func (c *ChargeTracker) Update(t *Target) (ChargeState, bool) {
+	if t == nil {
+		return c.state, false
+	}
+	next, falling := c.state, false
+	switch {
+	case t.Reported("Offline") && t.Offline:
+		next = Discharging
+	case !t.Reported("BCharge"):
+		if c.state == Discharging && t.Reported("Offline") {
+			next = Charging
+		}
+	case c.state == Charged && t.BCharge >= c.exit:
+	case t.BCharge >= c.enter:
+		next = Charged
+	case c.haveLast && (t.BCharge < c.last || t.BCharge == c.last && c.falling):
+		next, falling = Discharging, true
+	default:
+		next = Charging
+	}
+	if t.Reported("BCharge") {
+		c.last, c.haveLast = t.BCharge, true
+	}
+	c.falling = falling
+	changed := next != c.state
+	c.state = next
+	return next, changed
+}
+
+// ChargeStateEvent is sent by Watch, when enabled with
+// WithChargeTracking, each time the charging state of a UPS changes.
+// No event is sent for the state determined by the first sample.
+type ChargeStateEvent struct {
+	Addr   string
+	At     time.Time
+	Target *Target
+	// Previous and Current are the old and new states.
+	Previous, Current ChargeState
+}
+
+// Time returns when the change was observed.
+func (e ChargeStateEvent) Time() time.Time { return e.At }
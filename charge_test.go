@@ -0,0 +1,86 @@
+package apcupsc
+
+import (
+	"slices"
+	"testing"
+)
+
+// chargeSample returns a Target reporting only whether it is on
+// battery and, unless bcharge is negative, its BCharge.
+func chargeSample(onBattery bool, bcharge float64) *Target {
+	t := &Target{Offline: onBattery}
+	t.report("Offline")
+	if bcharge >= 0 {
+		t.BCharge = bcharge
+		t.report("BCharge")
+	}
+	return t
+}
+
+func TestChargeTracker(t *testing.T) {
+	const (
+		line = false
+		batt = true
+	)
+	type sample struct {
+		onBattery bool
+		bcharge   float64
+	}
+	for _, tc := range []struct {
+		name    string
+		samples []sample
+		want    []ChargeState
+	}{
+		{
+			name:    "charges",
+			samples: []sample{{line, 80}, {line, 90}, {line, 99}, {line, 100}},
+			want:    []ChargeState{Charging, Charged},
+		},
+		{
+			name:    "hovers",
+			samples: []sample{{line, 99}, {line, 98.9}, {line, 99.1}, {line, 96}, {line, 100}, {line, 95}},
+			want:    []ChargeState{Charged},
+		},
+		{
+			name:    "outage",
+			samples: []sample{{line, 100}, {batt, 97}, {batt, 80}, {line, 80}, {line, 85}, {line, 99}},
+			want:    []ChargeState{Charged, Discharging, Charging, Charged},
+		},
+		{
+			name:    "self test",
+			samples: []sample{{line, 90}, {line, 92}, {line, 88}, {line, 88}, {line, 85}, {line, 86}, {line, 86}},
+			want:    []ChargeState{Charging, Discharging, Charging},
+		},
+		{
+			name:    "leaves charged",
+			samples: []sample{{line, 100}, {line, 94}, {line, 94}, {line, 96}, {line, 98}, {line, 99}},
+			want:    []ChargeState{Charged, Discharging, Charging, Charged},
+		},
+		{
+			name:    "missed samples",
+			samples: []sample{{line, 80}, {line, -1}, {line, 81}, {line, -1}, {line, 99}},
+			want:    []ChargeState{Charging, Charged},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := NewChargeTracker(99, 95)
+			var got []ChargeState
+			for i, s := range tc.samples {
+				if state, changed := c.Update(chargeSample(s.onBattery, s.bcharge)); changed {
+					got = append(got, state)
+				} else if state != c.State() {
+					t.Fatalf("sample %d: Update returned %v but State is %v", i, state, c.State())
+				}
+				if i == 1 {
+					// A failed poll in between changes nothing.
+					if _, changed := c.Update(nil); changed {
+						t.Fatalf("sample %d: a nil Target changed the state", i)
+					}
+				}
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("transitions are %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
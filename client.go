@@ -0,0 +1,165 @@
+package apcupsc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// StatusSource is a source of UPS status samples. The higher level
+// parts of this package, such as WatchSource and NewFleetSources,
+// accept any StatusSource, and Client is the canonical
+// implementation.
+type StatusSource interface {
+	// Status samples the current status of the UPS.
+	Status(ctx context.Context) (*Target, error)
+	// Addr identifies the UPS being sampled.
+	Addr() string
+}
+
+// endpoint is a StatusSource that queries an apcupsd service with a
+// new connection for every sample. Concurrent queries of the same
+// service made with the same options share one connection, and each
+// caller receives its own copy of the result. A caller stops waiting when its context is done,
+// and the shared query is abandoned once every caller has.
+type endpoint struct {
+	addr string
+	opts []Option
+}
+
+// newEndpoint returns an endpoint for the apcupsd service at ep,
+// which is normalized as for Query.
+func newEndpoint(ep string, opts []Option) endpoint {
+	return endpoint{addr: newConfig(opts).addr(ep), opts: opts}
+}
+
+// Addr returns the endpoint address.
+func (e endpoint) Addr() string {
+	return e.addr
+}
+
+// Status queries the endpoint. Each caller is bounded by its own ctx
+// and any WithQueryTimeout, rather than those of the caller whose
+// query it shares.
+func (e endpoint) Status(ctx context.Context) (*Target, error) {
+	cfg := newConfig(e.opts)
+	if cfg.trace != nil || cfg.lineHook != nil || cfg.customDial {
+		// Each traced or hooked query must see its own exchange,
+		// made with its own Dialer and Resolver.
+		return Query(ctx, e.addr, e.opts...)
+	}
+	ctx, cancel := cfg.bound(ctx)
+	defer cancel()
+	return queries.do(ctx, cfg.flightKey(e.addr), func(ctx context.Context) (*Target, error) {
+		return cfg.sample(ctx, e.addr)
+	})
+}
+
+// queries coalesces the concurrent queries made by endpoints, since
+// some network management cards only accept one connection at a time
+// and reset the others.
+var queries flightGroup
+
+// flightKey identifies the queries of ep that can share a result: the
+// normalized address, the options that affect parsing and those that
+// affect the connection must match. Queries with a trace, a line
+// hook, or a Dialer or Resolver of their own, which cannot be
+// compared, are never shared.
+func (cfg *config) flightKey(ep string) string {
+	return fmt.Sprintf("%s %v %v %v %v %v %v %v %v %v %v", cfg.addr(ep), cfg.loc, cfg.threshold, cfg.maxRuntime, cfg.capacity, cfg.raw, cfg.extra, cfg.strictNumbers, cfg.proxy, cfg.dialTimeout, cfg.readTimeout)
+}
+
+// Client queries a single apcupsd service with a fixed set of
+// Options. A Client keeps its connection to the service open between
+// calls to Status, and is safe for concurrent use.
+type Client struct {
+	addr string
+	opts []Option
+
+	mu   sync.Mutex
+	conn *nisConn
+	// pinned is the resolved address last connected to, and
+	// pinnedAt when it was resolved, see WithResolveOnce.
+	pinned   string
+	pinnedAt time.Time
+}
+
+// NewClient returns a Client for the apcupsd service at ep. The opts
+// are applied to every query made by the Client.
+func NewClient(ep string, opts ...Option) *Client {
+	return &Client{
+		addr: newConfig(opts).addr(ep),
+		opts: opts,
+	}
+}
+
+// Addr returns the host:port address the Client queries.
+func (c *Client) Addr() string {
+	return c.addr
+}
+
+// Status queries the apcupsd service for its current status. The
+// connection from a previous call is reused when possible. If it
+// has failed, for example because the daemon disconnected it while
+// idle, Status redials once before giving up. Status is bounded as
+// described for Query. With WithResolveOnce, Status redials the
+// address it last connected to, and only resolves the Client's
+// hostname again if that fails or the address has expired.
+func (c *Client) Status(ctx context.Context) (*Target, error) {
+	cfg := newConfig(c.opts)
+	ctx, cancel := cfg.bound(ctx)
+	defer cancel()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		start := time.Now()
+		t, err := cfg.exchange(ctx, c.conn)
+		if err == nil {
+			t.QueryLatency = time.Since(start)
+			return t, nil
+		}
+		c.close()
+		if ctx.Err() != nil {
+			return nil, err
+		}
+	}
+	if cfg.resolveOnce && c.pinned != "" && (cfg.resolveTTL <= 0 || time.Since(c.pinnedAt) < cfg.resolveTTL) {
+		conn, t, err := cfg.query(ctx, c.pinned)
+		if err == nil {
+			c.conn = conn
+			return t, nil
+		}
+		c.pinned = ""
+		if ctx.Err() != nil {
+			return nil, err
+		}
+	}
+	conn, t, err := cfg.query(ctx, c.addr)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	if cfg.resolveOnce {
+		c.pinned, c.pinnedAt = conn.addr, time.Now()
+	}
+	return t, nil
+}
+
+// close closes any open connection. The caller must hold c.mu.
+func (c *Client) close() error {
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.c.Close()
+	c.conn = nil
+	return err
+}
+
+// Close closes the Client's connection, if open. The Client can
+// still be used after Close, and will redial as needed.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.close()
+}
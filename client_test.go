@@ -0,0 +1,308 @@
+package apcupsc
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientReuse(t *testing.T) {
+	addr, conns := startNIS(t, func(n int) []byte {
+		if n == 3 {
+			// The daemon drops the idle connection.
+			return nil
+		}
+		return frameLines(testDump)
+	})
+	c := NewClient(addr)
+	defer c.Close()
+	for i := 0; i < 6; i++ {
+		tgt, err := c.Status(context.Background())
+		if err != nil {
+			t.Fatalf("Status %d: %v", i, err)
+		}
+		if tgt.Name != "myapc" {
+			t.Fatalf("Status %d: Name = %q, want myapc", i, tgt.Name)
+		}
+	}
+	if n := conns.Load(); n != 2 {
+		t.Errorf("6 calls to Status made %d connections, want 1 and a redial", n)
+	}
+}
+
+// countingResolver resolves every hostname to its hosts, counting the
+// lookups made.
+type countingResolver struct {
+	mu      sync.Mutex
+	hosts   []string
+	lookups int
+}
+
+func (r *countingResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lookups++
+	return r.hosts, nil
+}
+
+// set changes the hosts r resolves to.
+func (r *countingResolver) set(hosts ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hosts = hosts
+}
+
+// count returns the number of lookups made so far.
+func (r *countingResolver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lookups
+}
+
+func TestClientResolveOnce(t *testing.T) {
+	nis, _ := startNIS(t, answer(testDump))
+	d := redirectDialer{"10.0.0.1:3551": nis, "10.0.0.2:3551": nis}
+	// poll queries the Client n times, closing its connection
+	// after each so that every query dials, and returns the count
+	// of lookups made.
+	poll := func(c *Client, r *countingResolver, n int) int {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			if _, err := c.Status(context.Background()); err != nil {
+				t.Fatalf("Status: %v", err)
+			}
+			c.Close()
+		}
+		return r.count()
+	}
+
+	r := &countingResolver{hosts: []string{"10.0.0.1"}}
+	c := NewClient("ups.test", WithResolver(r), WithDialer(d))
+	if got := poll(c, r, 5); got != 5 {
+		t.Errorf("without WithResolveOnce, 5 dials made %d lookups, want 5", got)
+	}
+
+	r = &countingResolver{hosts: []string{"10.0.0.1"}}
+	c = NewClient("ups.test", WithResolver(r), WithDialer(d), WithResolveOnce(0))
+	if got := poll(c, r, 5); got != 1 {
+		t.Errorf("with WithResolveOnce, 5 dials made %d lookups, want 1", got)
+	}
+	// The UPS moves, and is found again by a fresh lookup.
+	delete(d, "10.0.0.1:3551")
+	r.set("10.0.0.2")
+	if got := poll(c, r, 3); got != 2 {
+		t.Errorf("after the pinned address failed, %d lookups made, want 2", got)
+	}
+
+	r = &countingResolver{hosts: []string{"10.0.0.2"}}
+	c = NewClient("ups.test", WithResolver(r), WithDialer(d), WithResolveOnce(20*time.Millisecond))
+	if got := poll(c, r, 3); got != 1 {
+		t.Errorf("with WithResolveOnce(20ms), 3 quick dials made %d lookups, want 1", got)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if got := poll(c, r, 1); got != 2 {
+		t.Errorf("after the ttl, %d lookups made, want 2", got)
+	}
+}
+
+// failingResolver fails every lookup with its error.
+type failingResolver struct{ err error }
+
+func (r failingResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	return nil, r.err
+}
+
+// startCutNIS starts a fake apcupsd service that sends the first half
+// of its response to each status command and then closes the
+// connection, resetting it when reset is true.
+func startCutNIS(tb testing.TB, reset bool) string {
+	tb.Helper()
+	frames := frameLines(testDump)
+	addr, _ := listen(tb, func(c net.Conn) {
+		if _, err := readFrame(bufio.NewReader(c)); err != nil {
+			return
+		}
+		c.Write(frames[:len(frames)/2])
+		if reset {
+			c.(*net.TCPConn).SetLinger(0)
+		}
+	})
+	return addr
+}
+
+func TestQueryStages(t *testing.T) {
+	noSuchHost := errors.New("no such host")
+	cut, reset := startCutNIS(t, false), startCutNIS(t, true)
+	stages := []error{ErrResolve, ErrDial, ErrWrite, ErrRead, ErrIncomplete}
+	tests := []struct {
+		name string
+		ep   string
+		opts []Option
+		// want are the stage errors the failure wraps, and cause
+		// the underlying error, if known.
+		want  []error
+		cause error
+	}{
+		{
+			name:  "resolve",
+			ep:    "ups.test",
+			opts:  []Option{WithResolver(failingResolver{noSuchHost})},
+			want:  []error{ErrResolve},
+			cause: noSuchHost,
+		},
+		{
+			name: "resolve nothing",
+			ep:   "ups.test",
+			opts: []Option{WithResolver(&countingResolver{})},
+			want: []error{ErrResolve},
+		},
+		{
+			name: "dial",
+			ep:   "10.0.0.1",
+			opts: []Option{WithDialer(redirectDialer{})},
+			want: []error{ErrDial},
+		},
+		{
+			// The fake connection's far end is already closed.
+			name:  "write",
+			ep:    "10.0.0.1",
+			opts:  []Option{WithDialer(&fakeDialer{open: map[string]bool{"10.0.0.1:3551": true}})},
+			want:  []error{ErrWrite},
+			cause: io.ErrClosedPipe,
+		},
+		{
+			name: "reset mid-response",
+			ep:   reset,
+			want: []error{ErrRead, ErrIncomplete},
+		},
+		{
+			name: "closed mid-response",
+			ep:   cut,
+			want: []error{ErrIncomplete},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tgt, err := Query(context.Background(), tc.ep, tc.opts...)
+			if err == nil {
+				t.Fatalf("Query = %v, want an error", tgt)
+			}
+			for _, stage := range stages {
+				if errors.Is(err, stage) != slices.Contains(tc.want, stage) {
+					t.Errorf("errors.Is(%v, %v) = %v", err, stage, errors.Is(err, stage))
+				}
+			}
+			if strings.Contains(err.Error(), "%!") {
+				t.Errorf("error %q is badly formatted", err)
+			}
+			if tc.cause != nil && !errors.Is(err, tc.cause) {
+				t.Errorf("error %v does not wrap its cause %v", err, tc.cause)
+			}
+			// The batch helper reports the same stage.
+			r := ParseTargets([]string{tc.ep}, tc.opts...)
+			if !errors.Is(r[0].Err, tc.want[0]) || r[0].Target != nil {
+				t.Errorf("ParseTargets = %v, %v, want an error wrapping %v", r[0].Target, r[0].Err, tc.want[0])
+			}
+		})
+	}
+}
+
+// startSlowNIS starts a fake apcupsd service that answers each status
+// command one line every interval, much more slowly than it should.
+func startSlowNIS(tb testing.TB, interval time.Duration) string {
+	tb.Helper()
+	frames := frameLines(testDump)
+	addr, _ := listen(tb, func(c net.Conn) {
+		if _, err := readFrame(bufio.NewReader(c)); err != nil {
+			return
+		}
+		for b := frames; len(b) > 0; {
+			n := 2 + int(binary.BigEndian.Uint16(b))
+			if _, err := c.Write(b[:n]); err != nil {
+				return
+			}
+			b = b[n:]
+			time.Sleep(interval)
+		}
+	})
+	return addr
+}
+
+func TestQueryTimeout(t *testing.T) {
+	// The response would take more than a second to arrive.
+	addr := startSlowNIS(t, 30*time.Millisecond)
+	const timeout = 100 * time.Millisecond
+	ctxTimeout, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, tc := range []struct {
+		name  string
+		query func() (*Target, error)
+	}{
+		{"Query", func() (*Target, error) {
+			return Query(context.Background(), addr, WithQueryTimeout(timeout))
+		}},
+		{"Query context", func() (*Target, error) {
+			return Query(ctxTimeout, addr)
+		}},
+		{"Client", func() (*Target, error) {
+			c := NewClient(addr, WithQueryTimeout(timeout), WithReadTimeout(time.Second))
+			defer c.Close()
+			return c.Status(context.Background())
+		}},
+	} {
+		start := time.Now()
+		tgt, err := tc.query()
+		took := time.Since(start)
+		if err == nil {
+			t.Errorf("%s of a slow service = %v, want a timeout", tc.name, tgt)
+			continue
+		}
+		if !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("%s of a slow service: %v, want a deadline error", tc.name, err)
+		}
+		if took > 5*timeout {
+			t.Errorf("%s of a slow service took %v, want about %v", tc.name, took, timeout)
+		}
+	}
+}
+
+// BenchmarkClientStatus polls a local fake daemon over a connection
+// the Client keeps open, for comparison with BenchmarkQuery.
+func BenchmarkClientStatus(b *testing.B) {
+	addr, conns := startNIS(b, answer(testDump))
+	c := NewClient(addr)
+	defer c.Close()
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Status(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(conns.Load())/float64(b.N), "dials/op")
+}
+
+// BenchmarkQuery polls a local fake daemon with a new connection for
+// each poll.
+func BenchmarkQuery(b *testing.B) {
+	addr, conns := startNIS(b, answer(testDump))
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Query(ctx, addr); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(conns.Load())/float64(b.N), "dials/op")
+}
@@ -0,0 +1,111 @@
+package apcupsc
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// Change describes a Target field that differs between two samples.
+type Change struct {
+	// Field is the name of the Target field.
+	Field string
+	// Old and New are the field's values. Old is nil when the field
+	// was not previously known.
+	Old, New any
+}
+
+// String formats a Change for inclusion in a message.
+func (c Change) String() string {
+	if c.Old == nil {
+		return fmt.Sprintf("%s: %v", c.Field, c.New)
+	}
+	return fmt.Sprintf("%s: %v -> %v", c.Field, c.Old, c.New)
+}
+
+// diffConfig holds the settings of a Diff.
+type diffConfig struct {
+	ignore     map[string]bool
+	tolerances map[string]float64
+}
+
+// DiffOption configures Diff.
+type DiffOption func(*diffConfig)
+
+// DiffTolerance ignores changes of the named numeric field that are
+// no larger than tol. For time.Duration fields, tol is in seconds.
+func DiffTolerance(field string, tol float64) DiffOption {
+	return func(c *diffConfig) {
+		c.tolerances[field] = tol
+	}
+}
+
+// DiffIgnore ignores any changes of the named fields.
+func DiffIgnore(fields ...string) DiffOption {
+	return func(c *diffConfig) {
+		for _, f := range fields {
+			c.ignore[f] = true
+		}
+	}
+}
+
+// Diff returns the changes between the prev and curr samples, in
+// Target field order. Fields that curr does not report are skipped.
+// A nil prev reports every field of curr as new, and a nil curr
+// reports no changes. The DialLatency, QueryLatency, SampledAt and
+// Raw fields, which change with every sample, and the deprecated
+// fields are ignored.
+func Diff(prev, curr *Target, opts ...DiffOption) []Change {
+	cfg := &diffConfig{
+		ignore: map[string]bool{
+			"DialLatency":  true,
+			"QueryLatency": true,
+			"SampledAt":    true,
+			"Raw":          true,
+		},
+		tolerances: make(map[string]float64),
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if curr == nil {
+		return nil
+	}
+	var changes []Change
+	cv := reflect.ValueOf(curr).Elem()
+	for _, f := range reflect.VisibleFields(cv.Type()) {
+		if !f.IsExported() || deprecatedFields[f.Name] || cfg.ignore[f.Name] || !curr.Reported(f.Name) {
+			continue
+		}
+		nv := cv.FieldByIndex(f.Index)
+		if prev == nil || !prev.Reported(f.Name) {
+			changes = append(changes, Change{Field: f.Name, New: nv.Interface()})
+			continue
+		}
+		ov := reflect.ValueOf(prev).Elem().FieldByIndex(f.Index)
+		if !moved(ov, nv, cfg.tolerances[f.Name]) {
+			continue
+		}
+		changes = append(changes, Change{Field: f.Name, Old: ov.Interface(), New: nv.Interface()})
+	}
+	return changes
+}
+
+// moved reports whether the value of a field changed by more than
+// tol.
+func moved(ov, nv reflect.Value, tol float64) bool {
+	switch o := ov.Interface().(type) {
+	case time.Time:
+		return !o.Equal(nv.Interface().(time.Time))
+	case time.Duration:
+		return math.Abs((nv.Interface().(time.Duration) - o).Seconds()) > tol
+	}
+	switch ov.Kind() {
+	case reflect.Int, reflect.Int64:
+		return math.Abs(float64(nv.Int()-ov.Int())) > tol
+	case reflect.Float64:
+		return math.Abs(nv.Float()-ov.Float()) > tol
+	}
+	return !reflect.DeepEqual(ov.Interface(), nv.Interface())
+}
@@ -0,0 +1,139 @@
+package apcupsc
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statusLine formats a single apcupsd status line, padding the key
+// as apcupsd does.
+func statusLine(key, value string) string {
+	return fmt.Sprintf("%-9s: %s", key, value)
+}
+
+// formatNumber formats v with the fewest digits needed.
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// encode renders the Reported fields of t, followed by any Extra keys,
+// as the status lines, without trailing newlines, that apcupsd would
+// send to describe it. Timestamps are formatted in loc, and the final
+// "END APC" line is stamped with now.
+func (t *Target) encode(loc *time.Location, now time.Time) []string {
+	var lines []string
+	add := func(field, key, value string) {
+		if t.NotAvailable(field) {
+			lines = append(lines, statusLine(key, "N/A"))
+		} else if field == "" || t.Reported(field) {
+			lines = append(lines, statusLine(key, value))
+		}
+	}
+	stamp := func(field, key string, when time.Time) {
+		if !when.IsZero() || t.NotAvailable(field) {
+			add(field, key, formatTime(when, loc))
+		}
+	}
+	stamp("SampledAt", "DATE", t.SampledAt)
+	add("HostName", "HOSTNAME", t.HostName)
+	add("Version", "VERSION", t.Version)
+	add("Name", "UPSNAME", t.Name)
+	add("Cable", "CABLE", t.Cable)
+	add("Driver", "DRIVER", t.Driver)
+	add("Mode", "UPSMODE", t.Mode)
+	stamp("StartTime", "STARTTIME", t.StartTime)
+	if t.Reported("Status") && len(t.Status) > 0 {
+		add("", "STATUS", strings.Join(t.Status, " "))
+	} else if t.Offline {
+		add("Offline", "STATUS", "ONBATT")
+	} else {
+		add("Offline", "STATUS", "ONLINE")
+	}
+	add("LineV", "LINEV", fmt.Sprintf("%.1f Volts", t.LineV))
+	add("LowTransferV", "LOTRANS", fmt.Sprintf("%.1f Volts", t.LowTransferV))
+	add("HighTransferV", "HITRANS", fmt.Sprintf("%.1f Volts", t.HighTransferV))
+	add("OutputV", "OUTPUTV", fmt.Sprintf("%.1f Volts", t.OutputV))
+	add("OutCurrent", "OUTCURNT", fmt.Sprintf("%.2f Amps", t.OutCurrent))
+	add("LoadPct", "LOADPCT", fmt.Sprintf("%.1f Percent", t.LoadPct))
+	add("BCharge", "BCHARGE", fmt.Sprintf("%.1f Percent", t.BCharge))
+	add("TimeLeft", "TIMELEFT", fmt.Sprintf("%.1f Minutes", t.TimeLeft.Minutes()))
+	add("MinBCharge", "MBATTCHG", formatNumber(t.MinBCharge)+" Percent")
+	add("MinTimeLeft", "MINTIMEL", formatNumber(t.MinTimeLeft.Minutes())+" Minutes")
+	add("AmbientTemp", "AMBTEMP", fmt.Sprintf("%.1f C", t.AmbientTemp))
+	add("Humidity", "HUMIDITY", fmt.Sprintf("%.1f Percent", t.Humidity))
+	add("ExtBatteries", "EXTBATTS", strconv.Itoa(t.ExtBatteries))
+	add("BadBatteries", "BADBATTS", strconv.Itoa(t.BadBatteries))
+	add("MaxTime", "MAXTIME", formatNumber(t.MaxTime.Seconds())+" Seconds")
+	if t.AlarmDelay == 0 {
+		add("AlarmDelay", "ALARMDEL", "No alarm")
+	} else {
+		add("AlarmDelay", "ALARMDEL", formatNumber(t.AlarmDelay.Seconds())+" Seconds")
+	}
+	add("XFers", "NUMXFERS", strconv.Itoa(t.XFers))
+	stamp("LastOnBattery", "XONBATT", t.LastOnBattery)
+	if t.OutageInProgress || t.NotAvailable("Lasted") {
+		lines = append(lines, statusLine("XOFFBATT", "N/A"))
+	} else if t.Lasted > 0 {
+		stamp("Lasted", "XOFFBATT", t.LastOnBattery.Add(t.Lasted))
+	}
+	add("SelfTest", "SELFTEST", t.SelfTest)
+	if !t.BattDate.IsZero() || t.NotAvailable("BattDate") {
+		add("BattDate", "BATTDATE", t.BattDate.In(loc).Format(dateFormats[0]))
+	}
+	add("NomPower", "NOMPOWER", strconv.Itoa(t.NomPower)+" Watts")
+	var keys []string
+	for k := range t.Extra {
+		if k != "APC" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		lines = append(lines, statusLine(k, t.Extra[k]))
+	}
+	lines = append(lines, statusLine("END APC", formatTime(now, loc)))
+
+	// The leading APC line counts the lines and the bytes of the
+	// whole status.
+	size := 0
+	for _, line := range lines {
+		size += len(line) + 1
+	}
+	header := statusLine("APC", "")
+	size += len(header) + len("001,000,0000") + 1
+	header += fmt.Sprintf("001,%03d,%04d", len(lines)+1, size)
+	return append([]string{header}, lines...)
+}
+
+// Encode renders t as the status lines, without trailing newlines,
+// that apcupsd would report for it, as displayed by "apcaccess
+// status". Only the Reported fields and any Extra keys are included,
+// with timestamps formatted in TimeLocation. The "END APC" line is
+// stamped with SampledAt, or the current time if t has none.
+// ParseStatusText parses the result back into an equivalent Target,
+// to the precision of apcupsd's formatting.
+func (t *Target) Encode() []string {
+	now := t.SampledAt
+	if now.IsZero() {
+		now = time.Now()
+	}
+	return t.encode(TimeLocation, now)
+}
+
+// WriteTo writes the lines returned by Encode to w, each ending with a
+// newline. It implements io.WriterTo.
+func (t *Target) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	for _, line := range t.Encode() {
+		m, err := io.WriteString(w, line+"\n")
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,181 @@
+package apcupsc
+
+import (
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// randomWords returns up to n space separated random words, as
+// apcupsd reports in its text fields.
+func randomWords(r *rand.Rand, n int) string {
+	var words []string
+	for range 1 + r.IntN(n) {
+		var b strings.Builder
+		for range 1 + r.IntN(8) {
+			b.WriteByte("abcXYZ019.-_/()"[r.IntN(15)])
+		}
+		words = append(words, b.String())
+	}
+	return strings.Join(words, " ")
+}
+
+// randomStatus returns a Target as apcupsd could report it, holding
+// values only to the precision apcupsd formats them with. Each
+// supported key is either reported, reported as "N/A", or absent.
+func randomStatus(r *rand.Rand) *Target {
+	t := &Target{}
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, TimeLocation)
+	stamp := func() time.Time {
+		return base.Add(time.Duration(r.Int64N(5*365*24*3600)) * time.Second)
+	}
+	for _, k := range SupportedKeys() {
+		switch r.IntN(5) {
+		case 0:
+			continue
+		case 1:
+			if k.Field != "Status" {
+				t.unavailable(k.Field)
+				continue
+			}
+		}
+		t.report(k.Field)
+		switch k.Field {
+		case "SampledAt":
+			t.SampledAt = stamp()
+		case "StartTime":
+			t.StartTime = stamp()
+		case "LastOnBattery":
+			t.LastOnBattery = stamp()
+		case "HostName":
+			t.HostName = randomWords(r, 1)
+		case "Version":
+			t.Version = randomWords(r, 4)
+		case "Name":
+			t.Name = randomWords(r, 1)
+		case "Cable":
+			t.Cable = randomWords(r, 3)
+		case "Driver":
+			t.Driver = randomWords(r, 3)
+		case "Mode":
+			t.Mode = randomWords(r, 2)
+		case "SelfTest":
+			t.SelfTest = randomWords(r, 1)
+		case "Status":
+			flags := []string{"ONLINE", "ONBATT", "LOWBATT", "TRIM", "BOOST", "COMMLOST", "SHUTTING DOWN"}
+			r.Shuffle(len(flags), func(i, j int) { flags[i], flags[j] = flags[j], flags[i] })
+			t.Status = flags[:1+r.IntN(3)]
+			t.report("Offline")
+		case "LineV":
+			t.LineV = float64(r.IntN(3000)) / 10
+		case "LowTransferV":
+			t.LowTransferV = float64(r.IntN(3000)) / 10
+		case "HighTransferV":
+			t.HighTransferV = float64(r.IntN(3000)) / 10
+		case "OutputV":
+			t.OutputV = float64(r.IntN(3000)) / 10
+		case "OutCurrent":
+			t.OutCurrent = float64(r.IntN(10000)) / 100
+		case "LoadPct":
+			t.LoadPct = float64(r.IntN(1000)) / 10
+		case "BCharge":
+			t.BCharge = float64(r.IntN(1000)) / 10
+		case "MinBCharge":
+			t.MinBCharge = float64(r.IntN(1000)) / 10
+		case "AmbientTemp":
+			t.AmbientTemp = float64(r.IntN(1000)-200) / 10
+		case "Humidity":
+			t.Humidity = float64(r.IntN(1000)) / 10
+		case "TimeLeft":
+			t.TimeLeft = time.Duration(r.IntN(10000)) * 6 * time.Second
+		case "MinTimeLeft":
+			t.MinTimeLeft = time.Duration(r.IntN(60)) * time.Minute
+		case "MaxTime":
+			t.MaxTime = time.Duration(r.IntN(3600)) * time.Second
+		case "AlarmDelay":
+			t.AlarmDelay = time.Duration(r.IntN(120)) * time.Second
+		case "ExtBatteries":
+			t.ExtBatteries = r.IntN(10)
+		case "BadBatteries":
+			t.BadBatteries = r.IntN(10)
+		case "XFers":
+			t.XFers = r.IntN(100000)
+		case "NomPower":
+			t.NomPower = r.IntN(100000)
+		case "BattDate":
+			t.BattDate = base.AddDate(0, 0, r.IntN(5*365))
+		case "Lasted":
+			t.Lasted = time.Duration(1+r.IntN(3600)) * time.Second
+		}
+	}
+	if !t.Reported("LastOnBattery") && t.Reported("Lasted") {
+		// Lasted is encoded relative to XONBATT.
+		delete(t.reported, "Lasted")
+		t.Lasted = 0
+	}
+	for range r.IntN(4) {
+		var key strings.Builder
+		for range 1 + r.IntN(8) {
+			key.WriteByte(byte('A' + r.IntN(26)))
+		}
+		if parsedKeys[statusLine(key.String(), "")[:9]] != nil {
+			continue
+		}
+		if t.Extra == nil {
+			t.Extra = make(map[string]string)
+		}
+		t.Extra[key.String()] = randomWords(r, 3)
+		t.report("Extra")
+	}
+	return t
+}
+
+func TestEncodeParses(t *testing.T) {
+	r := rand.New(rand.NewPCG(3, 4))
+	for i := range 1000 {
+		want := randomStatus(r)
+		lines := want.Encode()
+		got, err := ParseStatusText(strings.NewReader(strings.Join(lines, "\n")+"\n"), WithExtra())
+		if err != nil {
+			t.Fatalf("%d: ParseStatusText: %v\n%s", i, err, strings.Join(lines, "\n"))
+		}
+		if !maps.Equal(got.Extra, want.Extra) {
+			t.Errorf("%d: Extra is %q, want %q", i, got.Extra, want.Extra)
+		}
+		for _, d := range diffFields(want, got) {
+			t.Errorf("%d: %s in:\n%s", i, d, strings.Join(lines, "\n"))
+		}
+	}
+}
+
+// diffFields describes how the fields of got, that SupportedKeys
+// parse into, differ from those of want, in their values or in being
+// Reported or NotAvailable.
+func diffFields(want, got *Target) []string {
+	var diffs []string
+	wv, gv := reflect.ValueOf(want).Elem(), reflect.ValueOf(got).Elem()
+	for _, k := range SupportedKeys() {
+		if got.Reported(k.Field) != want.Reported(k.Field) || got.NotAvailable(k.Field) != want.NotAvailable(k.Field) {
+			diffs = append(diffs, fmt.Sprintf("%s Reported %v NotAvailable %v, want %v and %v", k.Field,
+				got.Reported(k.Field), got.NotAvailable(k.Field), want.Reported(k.Field), want.NotAvailable(k.Field)))
+			continue
+		}
+		w, g := wv.FieldByName(k.Field).Interface(), gv.FieldByName(k.Field).Interface()
+		same := reflect.DeepEqual(w, g)
+		switch w := w.(type) {
+		case time.Time:
+			same = w.Equal(g.(time.Time))
+		case []string:
+			same = slices.Equal(w, g.([]string))
+		}
+		if !same {
+			diffs = append(diffs, fmt.Sprintf("%s is %v, want %v", k.Field, g, w))
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,58 @@
+package apcupsc
+
+import (
+	"sync"
+	"time"
+)
+
+// EnergyMeter integrates a sequence of power samples into the energy
+// consumed. It is safe for concurrent use.
+type EnergyMeter struct {
+	maxGap time.Duration
+
+	mu    sync.RWMutex
+	total float64
+	last  time.Time
+	watts float64
+}
+
+// NewEnergyMeter returns an EnergyMeter that limits the time credited
+// between two samples to maxGap, so that a period without samples
+// does not fabricate consumption. A zero maxGap does not limit the
+// time between samples.
+func NewEnergyMeter(maxGap time.Duration) *EnergyMeter {
+	return &EnergyMeter{maxGap: maxGap}
+}
+
+// Add records a sample of watts at time at. The energy since the
+// previous sample is estimated with the trapezoidal rule. Samples
+// that are not newer than the previous one are ignored.
+func (m *EnergyMeter) Add(at time.Time, watts float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.last.IsZero() {
+		if !at.After(m.last) {
+			return
+		}
+		dt := at.Sub(m.last)
+		if m.maxGap > 0 {
+			dt = min(dt, m.maxGap)
+		}
+		m.total += (m.watts + watts) / 2 * dt.Hours()
+	}
+	m.last, m.watts = at, watts
+}
+
+// Total returns the energy consumed in Watt Hours.
+func (m *EnergyMeter) Total() float64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.total
+}
+
+// Reset zeroes the total and forgets the previous sample.
+func (m *EnergyMeter) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.total, m.last, m.watts = 0, time.Time{}, 0
+}
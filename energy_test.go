@@ -0,0 +1,62 @@
+package apcupsc
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestEnergyMeter(t *testing.T) {
+	at := time.Date(2024, 10, 19, 11, 0, 0, 0, time.UTC)
+	type sample struct {
+		after time.Duration
+		watts float64
+	}
+	for _, tc := range []struct {
+		name    string
+		maxGap  time.Duration
+		samples []sample
+		want    float64
+	}{
+		{"none", 0, nil, 0},
+		{"one", 0, []sample{{0, 500}}, 0},
+		// 100 W for an hour is 100 Wh.
+		{"constant", 0, []sample{{0, 100}, {10 * time.Minute, 100}, {30 * time.Minute, 100}, {time.Hour, 100}}, 100},
+		// A ramp from 0 to 600 W over an hour averages 300 W,
+		// which the trapezoidal rule integrates exactly.
+		{"ramp", 0, []sample{{0, 0}, {15 * time.Minute, 150}, {30 * time.Minute, 300}, {45 * time.Minute, 450}, {time.Hour, 600}}, 300},
+		// 240 W for 1.5 hours, and then a ramp to 120 W over 30
+		// minutes: the 120 W sample at the same time as the last
+		// 240 W one is ignored.
+		{"step", 0, []sample{{0, 240}, {90 * time.Minute, 240}, {90 * time.Minute, 120}, {2 * time.Hour, 120}}, 360 + 90},
+		{"out of order", 0, []sample{{0, 100}, {time.Hour, 100}, {30 * time.Minute, 10000}, {2 * time.Hour, 100}}, 200},
+		// Only 5 minutes of an hour without samples are credited,
+		// at 120 W for 10 Wh, and the samples either side of the
+		// gap are integrated as usual.
+		{"gap", 5 * time.Minute, []sample{{0, 120}, {5 * time.Minute, 120}, {65 * time.Minute, 120}, {70 * time.Minute, 120}}, 30},
+		{"no gap limit", 0, []sample{{0, 120}, {5 * time.Minute, 120}, {65 * time.Minute, 120}, {70 * time.Minute, 120}}, 140},
+	} {
+		m := NewEnergyMeter(tc.maxGap)
+		for _, s := range tc.samples {
+			m.Add(at.Add(s.after), s.watts)
+		}
+		if got := m.Total(); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("%s: Total = %v Wh, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	m := NewEnergyMeter(0)
+	m.Add(at, 100)
+	m.Add(at.Add(time.Hour), 100)
+	m.Reset()
+	if got := m.Total(); got != 0 {
+		t.Errorf("Total after Reset = %v, want 0", got)
+	}
+	// The first sample after Reset starts a new integral, rather
+	// than crediting the time since the last sample before it.
+	m.Add(at.Add(2*time.Hour), 100)
+	m.Add(at.Add(150*time.Minute), 100)
+	if got := m.Total(); math.Abs(got-50) > 1e-9 {
+		t.Errorf("Total after Reset and half an hour at 100 W = %v, want 50", got)
+	}
+}
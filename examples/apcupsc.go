@@ -3,7 +3,6 @@ package main
 
 import (
 	"flag"
-	"fmt"
 	"log"
 	"sync"
 	"time"
@@ -25,7 +24,7 @@ func main() {
 		apcupsc.APCUPSDPort = *port
 	}
 
-	var targets = []string{fmt.Sprintf("%s:%d", *target, apcupsc.APCUPSDPort)}
+	var targets = []string{apcupsc.Endpoint(*target, apcupsc.APCUPSDPort)}
 	if *network != "" {
 		targets = apcupsc.Scan(*network, *timeout)
 		if len(targets) == 0 {
@@ -0,0 +1,224 @@
+package apcupsc
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testDump is the "apcaccess status" output of a Back-UPS XS 1500M,
+// which most tests are built around.
+const testDump = `APC      : 001,036,0879
+DATE     : 2024-10-19 11:46:30 -0700
+HOSTNAME : myhost
+VERSION  : 3.14.14 (31 May 2016) redhat
+UPSNAME  : myapc
+CABLE    : USB Cable
+DRIVER   : USB UPS Driver
+UPSMODE  : Stand Alone
+STARTTIME: 2024-10-01 10:00:00 -0700
+MODEL    : Back-UPS XS 1500M
+STATUS   : ONLINE
+LINEV    : 120.0 Volts
+LOADPCT  : 5.0 Percent
+BCHARGE  : 100.0 Percent
+TIMELEFT : 103.2 Minutes
+MBATTCHG : 5 Percent
+MINTIMEL : 3 Minutes
+MAXTIME  : 0 Seconds
+SENSE    : Medium
+LOTRANS  : 88.0 Volts
+HITRANS  : 139.0 Volts
+ALARMDEL : 30 Seconds
+BATTV    : 27.0 Volts
+LASTXFER : Unacceptable line voltage changes
+NUMXFERS : 1
+XONBATT  : 2024-10-03 03:11:10 -0700
+TONBATT  : 0 Seconds
+CUMONBATT: 2 Seconds
+XOFFBATT : 2024-10-03 03:11:12 -0700
+SELFTEST : NO
+STATFLAG : 0x05000008
+SERIALNO : 3B1234X12345
+BATTDATE : 2020-01-01
+NOMINV   : 120 Volts
+NOMBATTV : 24.0 Volts
+NOMPOWER : 900 Watts
+FIRMWARE : 947.d10 .D USB FW:d
+END APC  : 2024-10-19 11:46:33 -0700
+`
+
+// withLine returns dump with the line for key replaced by one holding
+// value, or with the line added before "END APC" if dump has none.
+func withLine(dump, key, value string) string {
+	line := statusLine(key, value) + "\n"
+	var out strings.Builder
+	found := false
+	for _, l := range strings.SplitAfter(dump, "\n") {
+		k, _, _ := strings.Cut(l, ":")
+		switch strings.TrimSpace(k) {
+		case key:
+			out.WriteString(line)
+			found = true
+			continue
+		case "END APC":
+			if !found {
+				out.WriteString(line)
+			}
+		}
+		out.WriteString(l)
+	}
+	return out.String()
+}
+
+// withoutLine returns dump without the line for key.
+func withoutLine(dump, key string) string {
+	var out strings.Builder
+	for _, l := range strings.SplitAfter(dump, "\n") {
+		if k, _, _ := strings.Cut(l, ":"); strings.TrimSpace(k) != key {
+			out.WriteString(l)
+		}
+	}
+	return out.String()
+}
+
+// frameLines encodes the lines of text as apcupsd sends them in
+// answer to a status command, ending with the empty frame.
+func frameLines(text string) []byte {
+	var b []byte
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if line == "" {
+			continue
+		}
+		b = binary.BigEndian.AppendUint16(b, uint16(len(line)))
+		b = append(b, line...)
+	}
+	return append(b, 0, 0)
+}
+
+// listen starts a loopback listener, closed when the test ends, that
+// calls handle on its own goroutine for each connection accepted. It
+// returns the listener's address and the count of connections
+// accepted.
+func listen(tb testing.TB, handle func(c net.Conn)) (string, *atomic.Int64) {
+	tb.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("listen: %v", err)
+	}
+	var (
+		conns atomic.Int64
+		wg    sync.WaitGroup
+	)
+	tb.Cleanup(func() {
+		l.Close()
+		wg.Wait()
+	})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns.Add(1)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer c.Close()
+				handle(c)
+			}()
+		}
+	}()
+	return l.Addr().String(), &conns
+}
+
+// startNIS starts a fake apcupsd network information server, closed
+// when the test ends. The n'th status command received by the server,
+// counting from 0 across all connections, is answered with the framed
+// response returned by respond(n). A nil response closes the
+// connection instead, as a daemon resetting it would. It returns the
+// server's address and the count of connections accepted.
+func startNIS(tb testing.TB, respond func(n int) []byte) (string, *atomic.Int64) {
+	tb.Helper()
+	var commands atomic.Int64
+	return listen(tb, func(c net.Conn) {
+		r := bufio.NewReader(c)
+		for {
+			cmd, err := readFrame(r)
+			if err != nil {
+				return
+			}
+			if cmd != "status" {
+				continue
+			}
+			resp := respond(int(commands.Add(1) - 1))
+			if resp == nil {
+				return
+			}
+			if _, err := c.Write(resp); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// answer returns a respond function for startNIS that answers every
+// status command with dump.
+func answer(dump string) func(int) []byte {
+	b := frameLines(dump)
+	return func(int) []byte { return b }
+}
+
+// script returns a respond function for startNIS that answers the
+// status commands with each of dumps in turn, repeating the last.
+func script(dumps ...string) func(int) []byte {
+	return func(n int) []byte {
+		return frameLines(dumps[min(n, len(dumps)-1)])
+	}
+}
+
+// waitFor polls cond until it holds, failing the test if it does not
+// within a few seconds.
+func waitFor(tb testing.TB, what string, cond func() bool) {
+	tb.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			tb.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// stubSource is a StatusSource whose n'th call to Status returns the
+// n'th of its results, repeating the last.
+type stubSource struct {
+	addr    string
+	results []stubResult
+
+	mu    sync.Mutex
+	calls int
+}
+
+// stubResult is a result returned by a stubSource.
+type stubResult struct {
+	t   *Target
+	err error
+}
+
+func (s *stubSource) Status(ctx context.Context) (*Target, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.results[min(s.calls, len(s.results)-1)]
+	s.calls++
+	return r.t.clone(), r.err
+}
+
+func (s *stubSource) Addr() string { return s.addr }
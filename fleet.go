@@ -0,0 +1,121 @@
+package apcupsc
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Result holds the outcome of querying a single apcupsd endpoint.
+type Result struct {
+	// Addr is the endpoint queried.
+	Addr string
+	// Target is the sampled status, or nil when Err is non-nil.
+	Target *Target
+	// Err is the reason the endpoint could not be sampled.
+	Err error
+}
+
+// ParseTargets concurrently queries each of the eps endpoints with
+// ParseTarget and returns their results in the same order. Queries
+// of an endpoint already being queried, by this or a concurrent call,
+// share that query's result rather than opening another connection.
+// Every Result holds its own Target, which the caller may modify.
+func ParseTargets(eps []string, opts ...Option) []Result {
+	srcs := make([]StatusSource, len(eps))
+	for i, ep := range eps {
+		srcs[i] = newEndpoint(ep, opts)
+	}
+	return QuerySources(context.Background(), srcs)
+}
+
+// QuerySources concurrently samples each of the srcs and returns
+// their results in the same order. The Addr of each Result is that
+// of its source.
+func QuerySources(ctx context.Context, srcs []StatusSource) []Result {
+	results := make([]Result, len(srcs))
+	var wg sync.WaitGroup
+	for i, src := range srcs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t, err := src.Status(ctx)
+			results[i] = Result{Addr: src.Addr(), Target: t, Err: err}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// FleetStatus holds the rollup of a set of UPS samples.
+type FleetStatus struct {
+	// Members is the number of UPSes in the fleet, including the
+	// Unreachable ones.
+	Members int
+	// Power is the total power consumption in Watts.
+	Power int
+	// Charge is the total stored energy in Watt Hours.
+	Charge int
+	// MinRuntime is the shortest non-zero backup runtime of any
+	// reachable UPS.
+	MinRuntime time.Duration
+	// OnBattery is the number of reachable UPSes that are Offline.
+	OnBattery int
+	// Unreachable lists the addresses of the UPSes that could not be
+	// sampled.
+	Unreachable []string
+}
+
+// Summarize rolls up a set of results into a FleetStatus. Results
+// with an error or without a Target are excluded from the sums and
+// listed as Unreachable. A Target that does not report Power or
+// Charge does not contribute to that sum.
+func Summarize(results []Result) FleetStatus {
+	fs := FleetStatus{Members: len(results)}
+	for _, r := range results {
+		if r.Err != nil || r.Target == nil {
+			fs.Unreachable = append(fs.Unreachable, r.Addr)
+			continue
+		}
+		t := r.Target
+		if t.Reported("Power") {
+			fs.Power += t.Power
+		}
+		if t.Reported("Charge") {
+			fs.Charge += t.Charge
+		}
+		if t.Offline {
+			fs.OnBattery++
+		}
+		if t.TimeLeft > 0 && (fs.MinRuntime == 0 || t.TimeLeft < fs.MinRuntime) {
+			fs.MinRuntime = t.TimeLeft
+		}
+	}
+	return fs
+}
+
+// Fleet is a set of UPS status sources that are sampled together.
+type Fleet struct {
+	srcs []StatusSource
+}
+
+// NewFleet returns a Fleet of the eps apcupsd endpoints, each queried
+// with opts.
+func NewFleet(eps []string, opts ...Option) *Fleet {
+	f := &Fleet{}
+	for _, ep := range eps {
+		f.srcs = append(f.srcs, newEndpoint(ep, opts))
+	}
+	return f
+}
+
+// NewFleetSources returns a Fleet of arbitrary status sources.
+func NewFleetSources(srcs ...StatusSource) *Fleet {
+	return &Fleet{srcs: append([]StatusSource(nil), srcs...)}
+}
+
+// Aggregate samples every member of the fleet and summarizes their
+// status.
+func (f *Fleet) Aggregate() FleetStatus {
+	return Summarize(QuerySources(context.Background(), f.srcs))
+}
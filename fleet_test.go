@@ -0,0 +1,326 @@
+package apcupsc
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseTargetsCoalesces(t *testing.T) {
+	const n = 8
+	var key string
+	addr, conns := startNIS(t, func(int) []byte {
+		// Hold the response until every query has joined the
+		// shared one.
+		for deadline := time.Now().Add(5 * time.Second); queries.waiting(key) < n && time.Now().Before(deadline); {
+			time.Sleep(time.Millisecond)
+		}
+		return frameLines(testDump)
+	})
+	key = newConfig(nil).flightKey(addr)
+	eps := make([]string, n)
+	for i := range eps {
+		eps[i] = addr
+	}
+	results := ParseTargets(eps)
+	if got := conns.Load(); got != 1 {
+		t.Errorf("server saw %d connections, want 1", got)
+	}
+	seen := make(map[*Target]bool)
+	for i, r := range results {
+		if r.Err != nil {
+			t.Fatalf("result %d: %v", i, r.Err)
+		}
+		if r.Target.Name != "myapc" || r.Target.Power != 45 {
+			t.Errorf("result %d: got %+v", i, r.Target)
+		}
+		if seen[r.Target] {
+			t.Errorf("result %d shares its Target with another result", i)
+		}
+		seen[r.Target] = true
+	}
+	results[0].Target.Status[0] = "ONBATT"
+	if got := results[1].Target.Status[0]; got != "ONLINE" {
+		t.Errorf("modifying one result changed another: Status[0]=%q", got)
+	}
+}
+
+// startHeldNIS starts a fake apcupsd service that holds its answer to
+// the first status command until release is closed, and answers the
+// others at once.
+func startHeldNIS(tb testing.TB, release <-chan struct{}) (string, *atomic.Int64) {
+	tb.Helper()
+	return startNIS(tb, func(n int) []byte {
+		if n == 0 {
+			select {
+			case <-release:
+			case <-time.After(5 * time.Second):
+			}
+		}
+		return frameLines(testDump)
+	})
+}
+
+// TestCoalescedTimeouts checks that the callers sharing a query are
+// each bounded by their own WithQueryTimeout, however they joined it.
+func TestCoalescedTimeouts(t *testing.T) {
+	// The timeout leaves the second query ample time to join the
+	// first before either gives up.
+	const timeout = 200 * time.Millisecond
+	for _, slowFirst := range []bool{false, true} {
+		release := make(chan struct{})
+		addr, conns := startHeldNIS(t, release)
+		key := newConfig(nil).flightKey(addr)
+		if k := newConfig([]Option{WithQueryTimeout(timeout)}).flightKey(addr); k != key {
+			t.Fatalf("WithQueryTimeout changed the flight key from %q to %q", key, k)
+		}
+		patientErr, hastyErr := make(chan error, 1), make(chan error, 1)
+		// run queries addr with opts, sending the outcome to errs.
+		run := func(errs chan<- error, opts ...Option) {
+			go func() {
+				tgt, err := newEndpoint(addr, opts).Status(context.Background())
+				if err == nil && tgt.Name != "myapc" {
+					err = errors.New("Name is " + tgt.Name)
+				}
+				errs <- err
+			}()
+		}
+		first, second := func() { run(patientErr) }, func() { run(hastyErr, WithQueryTimeout(timeout)) }
+		if slowFirst {
+			first, second = second, first
+		}
+		start := time.Now()
+		first()
+		waitFor(t, "the first query", func() bool { return queries.waiting(key) == 1 })
+		second()
+		select {
+		case err := <-hastyErr:
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("slowFirst=%v: the query with a timeout failed with %v, want a deadline error", slowFirst, err)
+			}
+			if took := time.Since(start); took > 10*timeout {
+				t.Errorf("slowFirst=%v: the query with a timeout took %v", slowFirst, took)
+			}
+		case err := <-patientErr:
+			t.Fatalf("slowFirst=%v: the query without a timeout returned %v before the response", slowFirst, err)
+		}
+		close(release)
+		if err := <-patientErr; err != nil {
+			t.Errorf("slowFirst=%v: the query without a timeout: %v", slowFirst, err)
+		}
+		if n := conns.Load(); n != 1 {
+			t.Errorf("slowFirst=%v: the server saw %d connections, want 1", slowFirst, n)
+		}
+	}
+}
+
+// TestFlightKeyConnection checks that queries made with different
+// connection options do not share a query in progress.
+func TestFlightKeyConnection(t *testing.T) {
+	proxy, asked := startProxy(t, 0)
+	for _, tc := range []struct {
+		name string
+		opt  Option
+	}{
+		{"proxy", WithHTTPProxy(proxy)},
+		{"dialer", WithDialer(&net.Dialer{})},
+		{"resolver", WithResolver(net.DefaultResolver)},
+		{"dial timeout", WithDialTimeout(time.Second)},
+		{"read timeout", WithReadTimeout(time.Second)},
+	} {
+		release := make(chan struct{})
+		addr, conns := startHeldNIS(t, release)
+		key := newConfig(nil).flightKey(addr)
+		direct := make(chan error, 1)
+		go func() {
+			_, err := newEndpoint(addr, nil).Status(context.Background())
+			direct <- err
+		}()
+		waitFor(t, "the direct query", func() bool { return queries.waiting(key) == 1 })
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		tgt, err := newEndpoint(addr, []Option{tc.opt}).Status(ctx)
+		cancel()
+		if err != nil || tgt.Name != "myapc" {
+			t.Errorf("%s: Status = %v, %v while the direct query is held, want its own answer", tc.name, tgt, err)
+		}
+		if n := queries.waiting(key); n != 1 {
+			t.Errorf("%s: %d callers wait for the direct query, want 1", tc.name, n)
+		}
+		close(release)
+		if err := <-direct; err != nil {
+			t.Errorf("%s: direct query: %v", tc.name, err)
+		}
+		if n := conns.Load(); n != 2 {
+			t.Errorf("%s: the server saw %d connections, want 2", tc.name, n)
+		}
+	}
+	if got := asked.Load().([]string); len(got) != 1 {
+		t.Errorf("the proxy was asked for %q, want one address", got)
+	}
+}
+
+// TestUnreportedPowerSkipped checks that the consumers of Power ignore
+// a sample taken with a frozen LOADPCT, rather than reading it as an
+// idle UPS.
+func TestUnreportedPowerSkipped(t *testing.T) {
+	parse := func(dump string) *Target {
+		t.Helper()
+		got, err := ParseStatusText(strings.NewReader(dump))
+		if err != nil {
+			t.Fatalf("ParseStatusText: %v", err)
+		}
+		return got
+	}
+	online := parse(testDump)
+	frozen := parse(withLine(withLine(testDump, "STATUS", "ONBATT"), "LOADPCT", "0.0 Percent"))
+	if frozen.Reported("Power") || frozen.Reported("Charge") {
+		t.Fatalf("a frozen LOADPCT reports Power %d and Charge %d", frozen.Power, frozen.Charge)
+	}
+
+	fs := Summarize([]Result{{Addr: "a", Target: online}, {Addr: "b", Target: frozen}})
+	if fs.Power != online.Power || fs.Charge != online.Charge || fs.OnBattery != 1 {
+		t.Errorf("Summarize is %+v, want Power %d and Charge %d of the online UPS alone", fs, online.Power, online.Charge)
+	}
+
+	s := NewPowerSmoother(0.5, time.Second)
+	at := time.Unix(0, 0)
+	s.Update("ups", online, at)
+	if w := s.Update("ups", frozen, at.Add(time.Second)); w != float64(online.Power) {
+		t.Errorf("PowerSmoother averaged in an unreported Power: %v", w)
+	}
+	s.Update("idle", frozen, at)
+	if w, ok := s.Watts("idle"); ok {
+		t.Errorf("PowerSmoother started an average of %v from an unreported Power", w)
+	}
+
+	m := NewEnergyMeter(0)
+	sink := newConfig([]Option{WithEnergyMeter(m)}).sinks[0]
+	sink("ups", online, at)
+	sink("ups", frozen, at.Add(30*time.Minute))
+	sink("ups", online, at.Add(time.Hour))
+	if want := float64(online.Power); m.Total() != want {
+		t.Errorf("EnergyMeter total is %v Wh, want %v Wh of the online samples alone", m.Total(), want)
+	}
+}
+
+// TestDaemonsOnOneHost runs two daemons on different ports of one
+// address, and checks that discovery, polling and aggregation each
+// treat them as distinct UPSes.
+func TestDaemonsOnOneHost(t *testing.T) {
+	now := time.Now().Format("2006-01-02 15:04:05 -0700")
+	dumpA := withLine(withLine(withLine(testDump, "DATE", now), "UPSNAME", "ups-a"), "LOADPCT", "10.0 Percent")
+	dumpB := withLine(withLine(withLine(withLine(testDump, "DATE", now), "UPSNAME", "ups-b"), "LOADPCT", "20.0 Percent"), "NOMPOWER", "1500 Watts")
+	addrA, _ := startNIS(t, answer(dumpA))
+	addrB, _ := startNIS(t, answer(dumpB))
+	names := map[string]string{addrA: "ups-a", addrB: "ups-b"}
+	var ports []int
+	for _, addr := range []string{addrA, addrB} {
+		ports = append(ports, int(netip.MustParseAddrPort(addr).Port()))
+	}
+	// A port with nothing listening on it.
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	ports = append(ports, closed.Addr().(*net.TCPAddr).Port)
+
+	found, err := ScanPorts(context.Background(), "127.0.0.1/32", ports)
+	if err != nil {
+		t.Fatalf("ScanPorts: %v", err)
+	}
+	var addrs []string
+	for _, r := range found {
+		addrs = append(addrs, r.Addr)
+	}
+	want := []string{addrA, addrB}
+	if ports[1] < ports[0] {
+		want = []string{addrB, addrA}
+	}
+	if !slices.Equal(addrs, want) {
+		t.Fatalf("ScanPorts found %v, want %v", addrs, want)
+	}
+
+	tracker := NewRuntimeTracker(time.Minute)
+	var (
+		mu   sync.Mutex
+		sunk = map[string]string{}
+	)
+	p := NewPoller(WithPollInterval(time.Millisecond), WithRuntimeTracker(tracker),
+		WithSink(func(addr string, t *Target, at time.Time) {
+			mu.Lock()
+			defer mu.Unlock()
+			sunk[addr] = t.Name
+		}))
+	for _, addr := range addrs {
+		p.Add(addr)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	events := p.Run(ctx)
+	waitFor(t, "both daemons to be polled", func() bool {
+		for _, s := range p.Snapshot() {
+			if s.Target == nil {
+				return false
+			}
+		}
+		return true
+	})
+	cancel()
+	for range events {
+	}
+	for _, s := range p.Snapshot() {
+		if s.Target.Name != names[s.Addr] {
+			t.Errorf("Poller status of %s is for %s, want %s", s.Addr, s.Target.Name, names[s.Addr])
+		}
+	}
+	if !maps.Equal(sunk, names) {
+		t.Errorf("sink saw %v, want %v", sunk, names)
+	}
+	if lows := tracker.Snapshot(); len(lows) != 2 || lows[addrA].LoadPct != 10 || lows[addrB].LoadPct != 20 {
+		t.Errorf("RuntimeTracker recorded %v, want a low for each of %s and %s", lows, addrA, addrB)
+	}
+
+	fs := NewFleet(addrs).Aggregate()
+	if fs.Members != 2 || len(fs.Unreachable) != 0 || fs.Power != 90+300 {
+		t.Errorf("Aggregate = %+v, want 2 reachable members drawing 390 Watts", fs)
+	}
+}
+
+func TestSourcesAggregate(t *testing.T) {
+	down := errors.New("down")
+	onBattery := &Target{Name: "b", Power: 300, Charge: 50, Offline: true, TimeLeft: 10 * time.Minute}
+	srcs := []StatusSource{
+		&stubSource{addr: "a", results: []stubResult{{t: &Target{Name: "a", Power: 90, Charge: 100, TimeLeft: time.Hour}}}},
+		&stubSource{addr: "b", results: []stubResult{{t: onBattery}}},
+		&stubSource{addr: "c", results: []stubResult{{err: down}}},
+	}
+	results := QuerySources(context.Background(), srcs)
+	for i, want := range []string{"a", "b", ""} {
+		r := results[i]
+		if r.Addr != srcs[i].Addr() {
+			t.Errorf("result %d is for %q, want %q", i, r.Addr, srcs[i].Addr())
+		}
+		if want == "" {
+			if !errors.Is(r.Err, down) || r.Target != nil {
+				t.Errorf("result %d = %v, %v, want the source's error", i, r.Target, r.Err)
+			}
+		} else if r.Err != nil || r.Target.Name != want {
+			t.Errorf("result %d = %v, %v, want %s", i, r.Target, r.Err, want)
+		}
+	}
+
+	fs := NewFleetSources(srcs...).Aggregate()
+	want := FleetStatus{Members: 3, Power: 390, Charge: 150, MinRuntime: 10 * time.Minute, OnBattery: 1, Unreachable: []string{"c"}}
+	if fs.Members != want.Members || fs.Power != want.Power || fs.Charge != want.Charge ||
+		fs.MinRuntime != want.MinRuntime || fs.OnBattery != want.OnBattery || !slices.Equal(fs.Unreachable, want.Unreachable) {
+		t.Errorf("Aggregate = %+v, want %+v", fs, want)
+	}
+}
@@ -0,0 +1,201 @@
+package apcupsc
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Health is the overall level of a HealthPolicy evaluation.
+type Health int
+
+// The Health levels in increasing order of severity.
+const (
+	OK Health = iota
+	Warning
+	Critical
+)
+
+// String returns the name of a Health level.
+func (h Health) String() string {
+	switch h {
+	case OK:
+		return "OK"
+	case Warning:
+		return "Warning"
+	case Critical:
+		return "Critical"
+	default:
+		return fmt.Sprintf("Health(%d)", int(h))
+	}
+}
+
+// Problem describes a single reason a Target is not healthy.
+type Problem struct {
+	// Level is the severity of the problem.
+	Level Health
+	// Field names the offending Target field.
+	Field string
+	// Value is the offending value.
+	Value any
+	// Reason explains the problem.
+	Reason string
+}
+
+// String formats a Problem for inclusion in an alert message.
+func (p Problem) String() string {
+	return fmt.Sprintf("%v: %s=%v: %s", p.Level, p.Field, p.Value, p.Reason)
+}
+
+// HealthPolicy holds the thresholds used to evaluate a Target. A
+// zero valued threshold disables the corresponding check, as does a
+// Target that does not report the field checked.
+type HealthPolicy struct {
+	// MinCharge is the BCharge percentage below which a warning is
+	// raised.
+	MinCharge float64
+	// MinRuntime is the TimeLeft below which a warning is raised.
+	MinRuntime time.Duration
+	// MaxLoadPct is the LoadPct above which a warning is raised.
+	MaxLoadPct float64
+	// MinTransferMargin is the headroom in Volts, see
+	// Target.TransferMargin, below which a warning is raised that
+	// the line voltage is approaching a transfer threshold.
+	MinTransferMargin float64
+	// MaxAge is the age beyond which a sample is considered stale,
+	// see Target.Fresh. A Target that has lost contact with its UPS
+	// is Critical regardless of MaxAge.
+	MaxAge time.Duration
+	// ClockSkew is the allowance made for the daemon's clock
+	// running behind the client's when checking MaxAge.
+	ClockSkew time.Duration
+	// MaxBatteryAge is the battery age beyond which
+	// Target.BatteryAdvisory recommends replacement.
+	MaxBatteryAge time.Duration
+}
+
+// DefaultHealthPolicy holds sensible thresholds for evaluating a
+// Target.
+var DefaultHealthPolicy = HealthPolicy{
+	MinCharge:         50,
+	MinRuntime:        10 * time.Minute,
+	MaxLoadPct:        80,
+	MaxAge:            5 * time.Minute,
+	ClockSkew:         DefaultClockSkew,
+	MinTransferMargin: 3,
+	// APC suggests replacing batteries every three to five years.
+	MaxBatteryAge: 4 * 365 * 24 * time.Hour,
+}
+
+// Evaluate assesses t against the policy, returning the overall
+// level and the problems found. A nil t, as returned by a failed
+// query, is Critical because communication with the UPS is lost. A
+// UPS on battery is a Warning, which becomes Critical when its charge
+// or runtime is also below the policy thresholds. A Target that is
+// not Fresh is Critical since its values cannot be trusted.
+func (p HealthPolicy) Evaluate(t *Target) (Health, []Problem) {
+	if t == nil {
+		return Critical, []Problem{{
+			Level:  Critical,
+			Field:  "Target",
+			Value:  nil,
+			Reason: "communication lost",
+		}}
+	}
+	var problems []Problem
+	if !t.Fresh(p.MaxAge, p.ClockSkew) {
+		if t.HasStatus("COMMLOST") {
+			problems = append(problems, Problem{
+				Level:  Critical,
+				Field:  "Status",
+				Value:  "COMMLOST",
+				Reason: "daemon lost contact with the UPS",
+			})
+		} else {
+			problems = append(problems, Problem{
+				Level:  Critical,
+				Field:  "SampledAt",
+				Value:  t.SampledAt,
+				Reason: fmt.Sprintf("sample older than %v", p.MaxAge),
+			})
+		}
+	}
+	low := false
+	if p.MinCharge > 0 && t.Reported("BCharge") && t.BCharge < p.MinCharge {
+		low = true
+		problems = append(problems, Problem{
+			Level:  Warning,
+			Field:  "BCharge",
+			Value:  t.BCharge,
+			Reason: fmt.Sprintf("charge below %v percent", p.MinCharge),
+		})
+	}
+	if p.MinRuntime > 0 && t.Reported("TimeLeft") && t.TimeLeft < p.MinRuntime {
+		low = true
+		problems = append(problems, Problem{
+			Level:  Warning,
+			Field:  "TimeLeft",
+			Value:  t.TimeLeft,
+			Reason: fmt.Sprintf("runtime below %v", p.MinRuntime),
+		})
+	}
+	if p.MaxLoadPct > 0 && t.Reported("LoadPct") && t.LoadPct > p.MaxLoadPct {
+		problems = append(problems, Problem{
+			Level:  Warning,
+			Field:  "LoadPct",
+			Value:  t.LoadPct,
+			Reason: fmt.Sprintf("load above %v percent", p.MaxLoadPct),
+		})
+	}
+	if low, high := t.TransferMargin(); p.MinTransferMargin > 0 && !t.Offline {
+		if low < p.MinTransferMargin {
+			problems = append(problems, Problem{
+				Level:  Warning,
+				Field:  "LineV",
+				Value:  t.LineV,
+				Reason: fmt.Sprintf("within %v Volts of the %v Volt low transfer threshold", p.MinTransferMargin, t.LowTransferV),
+			})
+		}
+		if high < p.MinTransferMargin {
+			problems = append(problems, Problem{
+				Level:  Warning,
+				Field:  "LineV",
+				Value:  t.LineV,
+				Reason: fmt.Sprintf("within %v Volts of the %v Volt high transfer threshold", p.MinTransferMargin, t.HighTransferV),
+			})
+		}
+	}
+	if t.Reported("BadBatteries") && t.BadBatteries > 0 {
+		problems = append(problems, Problem{
+			Level:  Warning,
+			Field:  "BadBatteries",
+			Value:  t.BadBatteries,
+			Reason: "defective battery packs",
+		})
+	}
+	if replace, reasons := t.BatteryAdvisory(time.Now(), p.MaxBatteryAge); replace {
+		problems = append(problems, Problem{
+			Level:  Warning,
+			Field:  "Battery",
+			Value:  strings.Join(reasons, "; "),
+			Reason: "battery needs replacement",
+		})
+	}
+	if t.Offline {
+		level := Warning
+		if low {
+			level = Critical
+		}
+		problems = append(problems, Problem{
+			Level:  level,
+			Field:  "Offline",
+			Value:  t.Offline,
+			Reason: "on battery power",
+		})
+	}
+	h := OK
+	for _, pr := range problems {
+		h = max(h, pr.Level)
+	}
+	return h, problems
+}
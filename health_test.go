@@ -0,0 +1,83 @@
+package apcupsc
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseDump parses dump as apcaccess text, failing the test if it
+// cannot.
+func parseDump(tb testing.TB, dump string, opts ...Option) *Target {
+	tb.Helper()
+	t, err := ParseStatusText(strings.NewReader(dump), opts...)
+	if err != nil {
+		tb.Fatalf("ParseStatusText: %v", err)
+	}
+	return t
+}
+
+func TestFresh(t *testing.T) {
+	commLost, err := os.ReadFile(filepath.Join("testdata", "backups-xs1500m-commlost.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dated := func(ago time.Duration) *Target {
+		return parseDump(t, withLine(testDump, "DATE", formatTime(time.Now().Add(-ago), time.UTC)))
+	}
+	policy := HealthPolicy{MaxAge: 5 * time.Minute, ClockSkew: DefaultClockSkew}
+	for _, tc := range []struct {
+		name string
+		t    *Target
+		// fresh is Fresh(5m, skew), and noAge is Fresh(0, 0).
+		skew         time.Duration
+		fresh, noAge bool
+		problemField string
+	}{
+		{name: "current", t: dated(0), skew: DefaultClockSkew, fresh: true, noAge: true},
+		{name: "within skew", t: dated(5*time.Minute + 3*time.Second), skew: DefaultClockSkew, fresh: true, noAge: true},
+		{name: "beyond skew", t: dated(5*time.Minute + 3*time.Second), skew: 0, noAge: true, problemField: "SampledAt"},
+		{name: "old DATE", t: parseDump(t, testDump), skew: DefaultClockSkew, noAge: true, problemField: "SampledAt"},
+		{name: "COMMLOST", t: parseDump(t, string(commLost)), skew: DefaultClockSkew, problemField: "Status"},
+		{name: "COMMLOST current", t: parseDump(t, withLine(string(commLost), "DATE", formatTime(time.Now(), time.UTC))), skew: DefaultClockSkew, problemField: "Status"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.t.Fresh(5*time.Minute, tc.skew); got != tc.fresh {
+				t.Errorf("Fresh(5m, %v) = %v, want %v", tc.skew, got, tc.fresh)
+			}
+			if got := tc.t.Fresh(0, 0); got != tc.noAge {
+				t.Errorf("Fresh(0, 0) = %v, want %v", got, tc.noAge)
+			}
+			policy := policy
+			policy.ClockSkew = tc.skew
+			h, problems := policy.Evaluate(tc.t)
+			if tc.problemField == "" {
+				if h != OK || len(problems) != 0 {
+					t.Errorf("Evaluate = %v, %v, want OK", h, problems)
+				}
+				return
+			}
+			if h != Critical || len(problems) != 1 || problems[0].Field != tc.problemField {
+				t.Errorf("Evaluate = %v, %v, want a Critical %s problem", h, problems, tc.problemField)
+			}
+		})
+	}
+}
+
+func TestPlausibleRuntime(t *testing.T) {
+	sample := parseDump(t, testDump)
+	if errs := sample.Validate(); len(errs) != 0 {
+		t.Errorf("Validate() = %v, want no errors", errs)
+	}
+	if errs := sample.Validate(WithMaxPlausibleRuntime(time.Hour)); len(errs) != 1 {
+		t.Errorf("Validate(1h) = %v, want TimeLeft %v rejected", errs, sample.TimeLeft)
+	}
+	if !sample.Reported("Lasted") || sample.Lasted != 2*time.Second {
+		t.Fatalf("Lasted is %v (reported %v), want 2s", sample.Lasted, sample.Reported("Lasted"))
+	}
+	if short := parseDump(t, testDump, WithMaxPlausibleRuntime(time.Second)); short.Reported("Lasted") {
+		t.Errorf("Lasted %v is reported beyond the plausible 1s", short.Lasted)
+	}
+}
@@ -0,0 +1,71 @@
+package apcupsc
+
+import (
+	"sync"
+	"time"
+)
+
+// Sample is a Target and the time it was taken.
+type Sample struct {
+	At     time.Time
+	Target *Target
+}
+
+// History holds the most recent samples of a UPS in a fixed size
+// ring buffer. It is safe for concurrent use.
+type History struct {
+	mu    sync.Mutex
+	buf   []Sample
+	next  int
+	count int
+}
+
+// NewHistory returns a History holding up to capacity samples. Once
+// full, each added sample evicts the oldest one.
+func NewHistory(capacity int) *History {
+	return &History{buf: make([]Sample, max(capacity, 1))}
+}
+
+// Add records t as sampled at time at.
+func (h *History) Add(t *Target, at time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.buf[h.next] = Sample{At: at, Target: t}
+	h.next = (h.next + 1) % len(h.buf)
+	h.count = min(h.count+1, len(h.buf))
+}
+
+// last returns the most recent n samples, oldest first. The caller
+// must hold h.mu.
+func (h *History) last(n int) []Sample {
+	n = min(max(n, 0), h.count)
+	ans := make([]Sample, n)
+	start := h.next - n
+	if start < 0 {
+		start += len(h.buf)
+	}
+	for i := range ans {
+		ans[i] = h.buf[(start+i)%len(h.buf)]
+	}
+	return ans
+}
+
+// Last returns up to the n most recent samples, oldest first.
+func (h *History) Last(n int) []Sample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.last(n)
+}
+
+// Since returns the held samples taken at or after t, oldest first.
+func (h *History) Since(t time.Time) []Sample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	all := h.last(h.count)
+	for i, s := range all {
+		if !s.At.Before(t) {
+			return all[i:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package apcupsc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	at := time.Date(2024, 10, 19, 11, 0, 0, 0, time.UTC)
+	stamp := func(i int) time.Time { return at.Add(time.Duration(i) * time.Second) }
+	// check compares samples with those added as numbers from to
+	// to, oldest first.
+	check := func(what string, got []Sample, from, to int) {
+		t.Helper()
+		if len(got) != max(to-from+1, 0) {
+			t.Errorf("%s returned %d samples, want %d to %d", what, len(got), from, to)
+			return
+		}
+		for i, s := range got {
+			if want := from + i; !s.At.Equal(stamp(want)) || s.Target.XFers != want {
+				t.Errorf("%s[%d] is sample %d at %v, want %d", what, i, s.Target.XFers, s.At, want)
+			}
+		}
+	}
+
+	h := NewHistory(4)
+	check("empty Last", h.Last(3), 0, -1)
+	check("empty Since", h.Since(at), 0, -1)
+	for i := 0; i < 3; i++ {
+		h.Add(&Target{XFers: i}, stamp(i))
+	}
+	check("partial Last", h.Last(10), 0, 2)
+	check("partial Last(2)", h.Last(2), 1, 2)
+	check("partial Since", h.Since(stamp(1)), 1, 2)
+	check("partial Since before", h.Since(at.Add(-time.Hour)), 0, 2)
+
+	// Adding more samples than the capacity wraps the ring,
+	// evicting the oldest.
+	for i := 3; i < 10; i++ {
+		h.Add(&Target{XFers: i}, stamp(i))
+	}
+	check("Last", h.Last(4), 6, 9)
+	check("Last(1)", h.Last(1), 9, 9)
+	check("Last(0)", h.Last(0), 0, -1)
+	check("Last(-1)", h.Last(-1), 0, -1)
+	check("Since evicted", h.Since(stamp(2)), 6, 9)
+	check("Since", h.Since(stamp(8)), 8, 9)
+	check("Since between", h.Since(stamp(7).Add(time.Millisecond)), 8, 9)
+	check("Since after", h.Since(stamp(10)), 0, -1)
+
+	// A History holds at least one sample.
+	h = NewHistory(0)
+	h.Add(&Target{XFers: 1}, stamp(1))
+	h.Add(&Target{XFers: 2}, stamp(2))
+	check("capacity 0 Last", h.Last(5), 2, 2)
+}
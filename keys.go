@@ -0,0 +1,233 @@
+package apcupsc
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// KeyInfo describes a status key that this package parses into a
+// Target field.
+type KeyInfo struct {
+	// Key is the status key, as apcupsd reports it, without padding.
+	Key string
+	// Field is the name of the Target field the key sets.
+	Field string
+	// Unit is the unit apcupsd reports the value in, or "" for
+	// values without one. Keys holding durations accept any unit
+	// ParseDuration recognizes.
+	Unit string
+	// Required is true when the key is needed to compute derived
+	// fields, such as Power, Charge, Charged, Offline or Lasted.
+	Required bool
+}
+
+// statusKey is a KeyInfo and how line digests the value of its status
+// line.
+type statusKey struct {
+	KeyInfo
+	// digest sets the field of p.t from the value of the status line,
+	// the text after "KEY      : ".
+	digest func(p *parser, value string) error
+	// also lists the fields, other than Field, that digesting the
+	// key sets.
+	also []string
+	// deferred is true when Field is only set once the response is
+	// complete, see parser.outage.
+	deferred bool
+	// anyUnit is true for durations, whose unit is not checked
+	// against Unit.
+	anyUnit bool
+}
+
+// statusKeys is the table that line dispatches status lines through,
+// in the order apcupsd reports them.
+var statusKeys = []statusKey{
+	{KeyInfo: KeyInfo{Key: "DATE", Field: "SampledAt"}, digest: timestamp(func(t *Target) *time.Time { return &t.SampledAt })},
+	{KeyInfo: KeyInfo{Key: "HOSTNAME", Field: "HostName"}, digest: text(func(t *Target) *string { return &t.HostName })},
+	{KeyInfo: KeyInfo{Key: "VERSION", Field: "Version"}, digest: text(func(t *Target) *string { return &t.Version })},
+	{KeyInfo: KeyInfo{Key: "UPSNAME", Field: "Name"}, digest: word(func(t *Target) *string { return &t.Name })},
+	{KeyInfo: KeyInfo{Key: "CABLE", Field: "Cable"}, digest: text(func(t *Target) *string { return &t.Cable })},
+	{KeyInfo: KeyInfo{Key: "DRIVER", Field: "Driver"}, digest: text(func(t *Target) *string { return &t.Driver })},
+	{KeyInfo: KeyInfo{Key: "UPSMODE", Field: "Mode"}, digest: text(func(t *Target) *string { return &t.Mode })},
+	{KeyInfo: KeyInfo{Key: "STARTTIME", Field: "StartTime"}, digest: timestamp(func(t *Target) *time.Time { return &t.StartTime })},
+	{KeyInfo: KeyInfo{Key: "STATUS", Field: "Status", Required: true}, also: []string{"Offline"}, digest: func(p *parser, value string) error {
+		p.t.Status = parseStatusFlags(value)
+		p.t.Offline = p.t.HasStatus("ONBATT")
+		return nil
+	}},
+	{KeyInfo: KeyInfo{Key: "LINEV", Field: "LineV", Unit: "Volts"}, digest: number(func(t *Target) *float64 { return &t.LineV })},
+	{KeyInfo: KeyInfo{Key: "LOTRANS", Field: "LowTransferV", Unit: "Volts"}, digest: number(func(t *Target) *float64 { return &t.LowTransferV })},
+	{KeyInfo: KeyInfo{Key: "HITRANS", Field: "HighTransferV", Unit: "Volts"}, digest: number(func(t *Target) *float64 { return &t.HighTransferV })},
+	{KeyInfo: KeyInfo{Key: "OUTPUTV", Field: "OutputV", Unit: "Volts"}, digest: number(func(t *Target) *float64 { return &t.OutputV })},
+	{KeyInfo: KeyInfo{Key: "OUTCURNT", Field: "OutCurrent", Unit: "Amps"}, digest: number(func(t *Target) *float64 { return &t.OutCurrent })},
+	{KeyInfo: KeyInfo{Key: "LOADPCT", Field: "LoadPct", Unit: "Percent", Required: true}, digest: number(func(t *Target) *float64 { return &t.LoadPct })},
+	{KeyInfo: KeyInfo{Key: "BCHARGE", Field: "BCharge", Unit: "Percent", Required: true}, also: []string{"Charged"}, digest: func(p *parser, value string) error {
+		if err := number(func(t *Target) *float64 { return &t.BCharge })(p, value); err != nil {
+			return err
+		}
+		p.t.Charged = p.t.BCharge >= p.cfg.threshold
+		return nil
+	}},
+	{KeyInfo: KeyInfo{Key: "TIMELEFT", Field: "TimeLeft", Unit: "Minutes", Required: true}, also: []string{"Backup"}, digest: duration(func(t *Target) *time.Duration { return &t.TimeLeft })},
+	{KeyInfo: KeyInfo{Key: "MBATTCHG", Field: "MinBCharge", Unit: "Percent"}, digest: number(func(t *Target) *float64 { return &t.MinBCharge })},
+	{KeyInfo: KeyInfo{Key: "MINTIMEL", Field: "MinTimeLeft", Unit: "Minutes"}, digest: duration(func(t *Target) *time.Duration { return &t.MinTimeLeft })},
+	{KeyInfo: KeyInfo{Key: "AMBTEMP", Field: "AmbientTemp", Unit: "C"}, digest: number(func(t *Target) *float64 { return &t.AmbientTemp })},
+	{KeyInfo: KeyInfo{Key: "HUMIDITY", Field: "Humidity", Unit: "Percent"}, digest: number(func(t *Target) *float64 { return &t.Humidity })},
+	{KeyInfo: KeyInfo{Key: "EXTBATTS", Field: "ExtBatteries"}, digest: integer(func(t *Target) *int { return &t.ExtBatteries })},
+	{KeyInfo: KeyInfo{Key: "BADBATTS", Field: "BadBatteries"}, digest: integer(func(t *Target) *int { return &t.BadBatteries })},
+	{KeyInfo: KeyInfo{Key: "MAXTIME", Field: "MaxTime", Unit: "Seconds"}, digest: duration(func(t *Target) *time.Duration { return &t.MaxTime })},
+	{KeyInfo: KeyInfo{Key: "ALARMDEL", Field: "AlarmDelay", Unit: "Seconds"}, digest: func(p *parser, value string) error {
+		if strings.TrimSpace(value) == "No alarm" {
+			p.t.AlarmDelay = 0
+			return nil
+		}
+		return duration(func(t *Target) *time.Duration { return &t.AlarmDelay })(p, value)
+	}},
+	{KeyInfo: KeyInfo{Key: "NUMXFERS", Field: "XFers"}, digest: integer(func(t *Target) *int { return &t.XFers })},
+	{KeyInfo: KeyInfo{Key: "XONBATT", Field: "LastOnBattery", Required: true}, also: []string{"LastOutage"}, digest: func(p *parser, value string) error {
+		if err := timestamp(func(t *Target) *time.Time { return &t.LastOnBattery })(p, value); err != nil {
+			return err
+		}
+		p.t.LastOutage = formatTime(p.t.LastOnBattery, p.cfg.loc)
+		return nil
+	}},
+	{KeyInfo: KeyInfo{Key: "XOFFBATT", Field: "Lasted", Required: true}, deferred: true, digest: func(p *parser, value string) error {
+		var err error
+		p.offBattery, err = ParseAPCTime(value, p.cfg.loc)
+		return err
+	}},
+	{KeyInfo: KeyInfo{Key: "SELFTEST", Field: "SelfTest"}, digest: word(func(t *Target) *string { return &t.SelfTest })},
+	{KeyInfo: KeyInfo{Key: "BATTDATE", Field: "BattDate"}, digest: func(p *parser, value string) error {
+		var err error
+		p.t.BattDate, err = parseDate(value, p.cfg.loc)
+		return err
+	}},
+	{KeyInfo: KeyInfo{Key: "NOMPOWER", Field: "NomPower", Unit: "Watts", Required: true}, digest: integer(func(t *Target) *int { return &t.NomPower })},
+}
+
+// text digests a value kept verbatim, but for surrounding space.
+func text(field func(*Target) *string) func(*parser, string) error {
+	return func(p *parser, value string) error {
+		*field(p.t) = strings.TrimSpace(value)
+		return nil
+	}
+}
+
+// word digests a value of which only the first word is kept.
+func word(field func(*Target) *string) func(*parser, string) error {
+	return func(p *parser, value string) error {
+		*field(p.t), _, _ = strings.Cut(value, " ")
+		return nil
+	}
+}
+
+// number digests a decimal value, see parser.parseNumber.
+func number(field func(*Target) *float64) func(*parser, string) error {
+	return func(p *parser, value string) error {
+		first, _, _ := strings.Cut(value, " ")
+		f, err := p.parseNumber(first)
+		if err != nil {
+			return err
+		}
+		*field(p.t) = f
+		return nil
+	}
+}
+
+// integer digests a whole value, see parser.parseInteger.
+func integer(field func(*Target) *int) func(*parser, string) error {
+	return func(p *parser, value string) error {
+		first, _, _ := strings.Cut(value, " ")
+		n, err := p.parseInteger(first)
+		if err != nil {
+			return err
+		}
+		*field(p.t) = n
+		return nil
+	}
+}
+
+// duration digests a value and its unit of time. Under
+// WithStrictNumbers a value with a comma is rejected.
+func duration(field func(*Target) *time.Duration) func(*parser, string) error {
+	return func(p *parser, value string) error {
+		if p.cfg.strictNumbers && strings.ContainsRune(value, ',') {
+			return fmt.Errorf("%q is not a plain decimal", value)
+		}
+		d, err := digestDuration(value)
+		if err != nil {
+			return err
+		}
+		*field(p.t) = d
+		return nil
+	}
+}
+
+// timestamp digests a value in one of the timeFormats.
+func timestamp(field func(*Target) *time.Time) func(*parser, string) error {
+	return func(p *parser, value string) error {
+		at, err := ParseAPCTime(value, p.cfg.loc)
+		if err != nil {
+			return err
+		}
+		*field(p.t) = at
+		return nil
+	}
+}
+
+// parsedKeys indexes statusKeys by their keys, padded as they appear
+// in status lines. Lines with other keys need not be converted to
+// strings.
+var parsedKeys = map[string]*statusKey{}
+
+func init() {
+	target := reflect.TypeOf(Target{})
+	for i := range statusKeys {
+		k := &statusKeys[i]
+		for _, f := range append([]string{k.Field}, k.also...) {
+			if _, ok := target.FieldByName(f); !ok {
+				panic(fmt.Sprintf("status key %s sets unknown field %q", k.Key, f))
+			}
+		}
+		f, _ := target.FieldByName(k.Field)
+		k.anyUnit = f.Type == reflect.TypeOf(time.Duration(0))
+		padded := fmt.Sprintf("%-9s", k.Key)
+		if parsedKeys[padded] != nil {
+			panic(fmt.Sprintf("status key %s listed twice", k.Key))
+		}
+		parsedKeys[padded] = k
+	}
+}
+
+// SupportedKeys returns the status keys this package parses into
+// Target fields, in the order apcupsd reports them. It is generated
+// from the table the parser dispatches status lines through, so the
+// two cannot disagree. Keys not listed are only retained in Extra,
+// see WithExtra.
+func SupportedKeys() []KeyInfo {
+	keys := make([]KeyInfo, len(statusKeys))
+	for i, k := range statusKeys {
+		keys[i] = k.KeyInfo
+	}
+	return keys
+}
+
+// UnsupportedKeys returns, in sorted order, the keys of t.Extra that
+// are not among the SupportedKeys, that is the keys a daemon reported
+// that this package does not capture. The "APC" header line is not
+// included. The result is only meaningful for a Target queried with
+// WithExtra.
+func UnsupportedKeys(t *Target) []string {
+	var keys []string
+	for k := range t.Extra {
+		if k == "APC" || parsedKeys[fmt.Sprintf("%-9s", k)] != nil {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,226 @@
+package apcupsc
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// keySamples holds a representative value for each supported key.
+var keySamples = map[string]string{
+	"DATE":      "2024-10-19 11:46:30 -0700",
+	"HOSTNAME":  "myhost",
+	"VERSION":   "3.14.14 (31 May 2016) redhat",
+	"UPSNAME":   "myapc",
+	"CABLE":     "USB Cable",
+	"DRIVER":    "USB UPS Driver",
+	"UPSMODE":   "Stand Alone",
+	"STARTTIME": "2024-10-01 10:00:00 -0700",
+	"STATUS":    "ONLINE",
+	"LINEV":     "120.0 Volts",
+	"LOTRANS":   "88.0 Volts",
+	"HITRANS":   "139.0 Volts",
+	"OUTPUTV":   "120.0 Volts",
+	"OUTCURNT":  "0.52 Amps",
+	"LOADPCT":   "5.0 Percent",
+	"BCHARGE":   "100.0 Percent",
+	"TIMELEFT":  "103.2 Minutes",
+	"MBATTCHG":  "5 Percent",
+	"MINTIMEL":  "3 Minutes",
+	"AMBTEMP":   "25.5 C",
+	"HUMIDITY":  "40.0 Percent",
+	"EXTBATTS":  "1",
+	"BADBATTS":  "0",
+	"MAXTIME":   "0 Seconds",
+	"ALARMDEL":  "30 Seconds",
+	"NUMXFERS":  "1",
+	"XONBATT":   "2024-10-03 03:11:10 -0700",
+	"XOFFBATT":  "2024-10-03 03:11:12 -0700",
+	"SELFTEST":  "NO",
+	"BATTDATE":  "2020-01-01",
+	"NOMPOWER":  "900 Watts",
+}
+
+// TestSupportedKeysParsed checks that the registry and the parser
+// agree: every key SupportedKeys lists is parsed into the Field and
+// the units it names, and nothing else is.
+func TestSupportedKeysParsed(t *testing.T) {
+	keys := SupportedKeys()
+	var dump strings.Builder
+	for _, k := range keys {
+		value, ok := keySamples[k.Key]
+		if !ok {
+			t.Errorf("no sample value for supported key %s", k.Key)
+			continue
+		}
+		if _, unit, _ := strings.Cut(value, " "); k.Unit != "" && unit != k.Unit {
+			t.Errorf("sample %s value %q is not in the registered unit %q", k.Key, value, k.Unit)
+		}
+		fmt.Fprintln(&dump, statusLine(k.Key, value))
+	}
+	fmt.Fprintln(&dump, statusLine("END APC", "2024-10-19 11:46:33 -0700"))
+	if len(keys) != len(keySamples) {
+		t.Errorf("SupportedKeys lists %d keys, the samples hold %d", len(keys), len(keySamples))
+	}
+
+	got, err := ParseStatusText(strings.NewReader(dump.String()), WithExtra())
+	if err != nil {
+		t.Fatalf("ParseStatusText: %v", err)
+	}
+	for _, k := range keys {
+		if !got.Reported(k.Field) {
+			t.Errorf("%s: field %s not Reported", k.Key, k.Field)
+		}
+	}
+	if got.Extra != nil {
+		t.Errorf("supported keys were retained in Extra: %v", got.Extra)
+	}
+	if u := UnsupportedKeys(got); len(u) != 0 {
+		t.Errorf("UnsupportedKeys = %v, want none", u)
+	}
+
+	// Each key reported as N/A is NotAvailable in its registered
+	// field alone.
+	for _, k := range keys {
+		one, err := ParseStatusText(strings.NewReader(statusLine(k.Key, "N/A") + "\nEND APC  : x\n"))
+		if err != nil {
+			t.Fatalf("%s N/A: %v", k.Key, err)
+		}
+		if !one.NotAvailable(k.Field) || one.Reported(k.Field) {
+			t.Errorf("%s N/A: NotAvailable(%s)=%v Reported=%v", k.Key, k.Field, one.NotAvailable(k.Field), one.Reported(k.Field))
+		}
+	}
+}
+
+func TestSupportedKeysUnits(t *testing.T) {
+	for _, k := range SupportedKeys() {
+		if k.Unit == "" || parsedKeys[fmt.Sprintf("%-9s", k.Key)].anyUnit {
+			continue
+		}
+		first, _, _ := strings.Cut(keySamples[k.Key], " ")
+		got, err := ParseStatusText(strings.NewReader(statusLine(k.Key, first+" Furlongs") + "\nEND APC  : x\n"))
+		if err != nil {
+			t.Fatalf("%s: %v", k.Key, err)
+		}
+		if got.Reported(k.Field) {
+			t.Errorf("%s in the wrong unit was parsed into %s", k.Key, k.Field)
+		}
+	}
+}
+
+func TestUnsupportedKeys(t *testing.T) {
+	got, err := ParseStatusText(strings.NewReader(testDump), WithExtra())
+	if err != nil {
+		t.Fatalf("ParseStatusText: %v", err)
+	}
+	want := []string{"BATTV", "CUMONBATT", "FIRMWARE", "LASTXFER", "MODEL", "NOMBATTV", "NOMINV", "SENSE", "SERIALNO", "STATFLAG", "TONBATT"}
+	if u := UnsupportedKeys(got); !slices.Equal(u, want) {
+		t.Errorf("UnsupportedKeys = %v, want %v", u, want)
+	}
+}
+
+// minimalDump is the status of a small Back-UPS that reports neither
+// its line voltage nor its nominal power, nor any of the timestamps
+// but for the sample's own.
+const minimalDump = `APC      : 001,011,0252
+DATE     : 2024-10-19 11:46:30 -0700
+HOSTNAME : myhost
+UPSNAME  : myapc
+STATUS   : ONLINE
+LOADPCT  : 12.0 Percent
+BCHARGE  : 100.0 Percent
+TIMELEFT : 41.5 Minutes
+NUMXFERS : 0
+XONBATT  : N/A
+END APC  : 2024-10-19 11:46:31 -0700
+`
+
+func TestMinimalPresence(t *testing.T) {
+	got, err := ParseStatusText(strings.NewReader(minimalDump))
+	if err != nil {
+		t.Fatalf("ParseStatusText: %v", err)
+	}
+	for _, f := range []string{"SampledAt", "Name", "Status", "Offline", "LoadPct", "BCharge", "Charged", "TimeLeft", "Backup", "XFers"} {
+		if !got.Reported(f) {
+			t.Errorf("%s not Reported", f)
+		}
+	}
+	absent := []string{"LineV", "OutputV", "NomPower", "Power", "Charge", "StartTime", "BattDate", "LastOnBattery", "LastOutage", "Lasted", "OutageInProgress"}
+	for _, f := range absent {
+		if got.Reported(f) {
+			t.Errorf("%s Reported by a UPS that does not report it", f)
+		}
+	}
+	if !got.NotAvailable("LastOnBattery") {
+		t.Errorf("LastOnBattery reported as N/A is not NotAvailable")
+	}
+	// A reported zero is still reported.
+	if !got.Reported("XFers") || got.XFers != 0 {
+		t.Errorf("XFers = %d, Reported %v, want a reported 0", got.XFers, got.Reported("XFers"))
+	}
+
+	b, err := got.MarshalJSON()
+	if err != nil {
+		t.Fatalf("MarshalJSON: %v", err)
+	}
+	for _, f := range absent {
+		if strings.Contains(string(b), fmt.Sprintf("%q:", f)) {
+			t.Errorf("JSON includes unreported %s: %s", f, b)
+		}
+	}
+	for _, f := range []string{"SampledAt", "LoadPct", "XFers"} {
+		if !strings.Contains(string(b), fmt.Sprintf("%q:", f)) {
+			t.Errorf("JSON omits reported %s: %s", f, b)
+		}
+	}
+}
+
+// TestNotAvailableFixture checks the keys a Back-UPS ES reports as
+// N/A, and that each is told apart from a disabled, zero, value and
+// from an absent key.
+func TestNotAvailableFixture(t *testing.T) {
+	dump, err := os.ReadFile(filepath.Join("testdata", "backups-es700-na.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseStatusText(bytes.NewReader(dump))
+	if err != nil {
+		t.Fatalf("ParseStatusText: %v", err)
+	}
+	for _, f := range []string{"MaxTime", "AlarmDelay", "Lasted", "NomPower"} {
+		if !got.NotAvailable(f) || got.Reported(f) {
+			t.Errorf("%s: NotAvailable %v Reported %v, want not available", f, got.NotAvailable(f), got.Reported(f))
+		}
+	}
+	if got.MaxTime != 0 || got.AlarmDelay != 0 || got.Lasted != 0 || got.NomPower != 0 {
+		t.Errorf("fields not available hold MaxTime %v AlarmDelay %v Lasted %v NomPower %d, want zeros",
+			got.MaxTime, got.AlarmDelay, got.Lasted, got.NomPower)
+	}
+	// Without NOMPOWER the load cannot be converted to Watts.
+	if got.Reported("Power") || got.Reported("Charge") {
+		t.Errorf("Power %d and Charge %d are derived from a NOMPOWER of N/A", got.Power, got.Charge)
+	}
+
+	for _, tc := range []struct {
+		key, field, zero string
+	}{
+		{"MAXTIME", "MaxTime", "0 Seconds"},
+		{"ALARMDEL", "AlarmDelay", "No alarm"},
+		{"NOMPOWER", "NomPower", "0 Watts"},
+	} {
+		disabled := parseDump(t, withLine(testDump, tc.key, tc.zero))
+		if !disabled.Reported(tc.field) || disabled.NotAvailable(tc.field) {
+			t.Errorf("%s %q: Reported %v NotAvailable %v, want a reported zero", tc.key, tc.zero,
+				disabled.Reported(tc.field), disabled.NotAvailable(tc.field))
+		}
+		absent := parseDump(t, withoutLine(testDump, tc.key))
+		if absent.Reported(tc.field) || absent.NotAvailable(tc.field) {
+			t.Errorf("absent %s: Reported %v NotAvailable %v, want neither", tc.key,
+				absent.Reported(tc.field), absent.NotAvailable(tc.field))
+		}
+	}
+}
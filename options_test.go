@@ -0,0 +1,253 @@
+package apcupsc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWithLineHook(t *testing.T) {
+	dump := withLine(testDump, "VENDORX", "42 Widgets")
+	var vendor string
+	hook := func(key, value string) bool {
+		switch key {
+		case "VENDORX":
+			vendor = value
+			return true
+		case "LINEV":
+			return true
+		}
+		return false
+	}
+	got, err := ParseStatusText(strings.NewReader(dump), WithLineHook(hook), WithExtra())
+	if err != nil {
+		t.Fatalf("ParseStatusText: %v", err)
+	}
+	if vendor != "42 Widgets" {
+		t.Errorf("hook captured VENDORX=%q, want %q", vendor, "42 Widgets")
+	}
+	if got.Reported("LineV") || got.LineV != 0 {
+		t.Errorf("LINEV was parsed despite the hook: LineV=%v", got.LineV)
+	}
+	if _, ok := got.Extra["VENDORX"]; ok {
+		t.Error("a key handled by the hook was retained in Extra")
+	}
+	if got.Extra["MODEL"] != "Back-UPS XS 1500M" {
+		t.Errorf("Extra[MODEL]=%q; keys the hook declines should still be retained", got.Extra["MODEL"])
+	}
+	if !got.Reported("BCharge") || got.BCharge != 100 {
+		t.Errorf("BCharge=%v: the rest of the dump should be parsed", got.BCharge)
+	}
+}
+
+func TestParseOptionsNotCoalesced(t *testing.T) {
+	var (
+		release sync.Once
+		conns   *atomic.Int64
+		addr    string
+	)
+	joined := make(chan struct{})
+	addr, conns = startNIS(t, func(n int) []byte {
+		if n == 0 {
+			// Hold the first response until the other queries
+			// are in progress, to give them the chance to join
+			// it.
+			select {
+			case <-joined:
+			case <-time.After(5 * time.Second):
+			}
+		} else if conns.Load() == 3 {
+			release.Do(func() { close(joined) })
+		}
+		return frameLines(testDump)
+	})
+
+	var hooked atomic.Int64
+	hook := func(key, value string) bool {
+		hooked.Add(1)
+		return false
+	}
+	var plain, extra, withHook []Result
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		plain = ParseTargets([]string{addr})
+	}()
+	waitFor(t, "plain query", func() bool { return conns.Load() == 1 })
+	go func() {
+		defer wg.Done()
+		extra = ParseTargets([]string{addr}, WithExtra())
+	}()
+	go func() {
+		defer wg.Done()
+		withHook = ParseTargets([]string{addr}, WithExtra(), WithLineHook(hook))
+	}()
+	wg.Wait()
+
+	if got := conns.Load(); got != 3 {
+		t.Errorf("server saw %d connections, want 3", got)
+	}
+	for _, r := range [][]Result{plain, extra, withHook} {
+		if r[0].Err != nil {
+			t.Fatalf("query failed: %v", r[0].Err)
+		}
+	}
+	if plain[0].Target.Extra != nil {
+		t.Errorf("plain query got Extra %v", plain[0].Target.Extra)
+	}
+	for name, r := range map[string][]Result{"WithExtra": extra, "WithLineHook": withHook} {
+		if r[0].Target.Extra["SERIALNO"] != "3B1234X12345" {
+			t.Errorf("%s query got Extra %v", name, r[0].Target.Extra)
+		}
+	}
+	if hooked.Load() == 0 {
+		t.Error("line hook was never called")
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	tests := []struct {
+		host string
+		port int
+		want string
+	}{
+		{"10.0.0.1", 3551, "10.0.0.1:3551"},
+		{"fd00:1::10", 3551, "[fd00:1::10]:3551"},
+		{"[fd00:1::10]", 3551, "[fd00:1::10]:3551"},
+		{"FD00:0001:0:0::10", 3552, "[fd00:1::10]:3552"},
+		{"::ffff:10.0.0.1", 3551, "[::ffff:10.0.0.1]:3551"},
+		{"ups.example.com", 3551, "ups.example.com:3551"},
+		{"UPS.Example.COM", 3551, "ups.example.com:3551"},
+	}
+	for _, tc := range tests {
+		if got := Endpoint(tc.host, tc.port); got != tc.want {
+			t.Errorf("Endpoint(%q, %d) = %q, want %q", tc.host, tc.port, got, tc.want)
+		}
+	}
+}
+
+func TestConfigAddr(t *testing.T) {
+	tests := []struct {
+		ep, want string
+	}{
+		{"10.0.0.1", "10.0.0.1:3551"},
+		{"10.0.0.1:3552", "10.0.0.1:3552"},
+		{"fd00:1::10", "[fd00:1::10]:3551"},
+		{"[fd00:1::10]", "[fd00:1::10]:3551"},
+		{"[fd00:1::10]:3552", "[fd00:1::10]:3552"},
+		{"[FD00:1:0::10]:3552", "[fd00:1::10]:3552"},
+		{"ups.example.com", "ups.example.com:3551"},
+		{"UPS.example.com:3552", "ups.example.com:3552"},
+		{"localhost", "localhost:3551"},
+		// A named port is left for the dialer to look up.
+		{"ups.example.com:apcupsd", "ups.example.com:apcupsd"},
+	}
+	cfg := newConfig(nil)
+	for _, tc := range tests {
+		if got := cfg.addr(tc.ep); got != tc.want {
+			t.Errorf("addr(%q) = %q, want %q", tc.ep, got, tc.want)
+		}
+		// Normalized addresses are left as they are, so a
+		// scanned address queries the UPS it names.
+		if got := cfg.addr(cfg.addr(tc.ep)); got != tc.want {
+			t.Errorf("addr(addr(%q)) = %q, want %q", tc.ep, got, tc.want)
+		}
+	}
+	if got := newConfig([]Option{WithPort(3600)}).addr("fd00:1::10"); got != "[fd00:1::10]:3600" {
+		t.Errorf("addr with WithPort(3600) = %q, want [fd00:1::10]:3600", got)
+	}
+	if got, want := scanAddr(ip4(10, 0, 0, 1), 3551), cfg.addr("10.0.0.1"); got != want {
+		t.Errorf("scanned address %q differs from queried %q", got, want)
+	}
+}
+
+// TestConcurrentLocations queries concurrently with different
+// locations, which run with -race shows share no state.
+func TestConcurrentLocations(t *testing.T) {
+	addr, _ := startNIS(t, answer(testDump))
+	var locs []*time.Location
+	for _, name := range []string{"UTC", "Asia/Tokyo", "Europe/Berlin", "America/Los_Angeles"} {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		locs = append(locs, loc)
+	}
+	framed := frameLines(testDump)
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		loc := locs[i%len(locs)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var (
+				got *Target
+				err error
+			)
+			if i%2 == 0 {
+				got, err = Query(context.Background(), addr, WithLocation(loc))
+			} else {
+				got, err = ParseStatus(bytes.NewReader(framed), WithLocation(loc))
+			}
+			if err != nil {
+				t.Errorf("%v: %v", loc, err)
+				return
+			}
+			if want := time.Date(2020, 1, 1, 0, 0, 0, 0, loc); !got.BattDate.Equal(want) || got.BattDate.Location() != loc {
+				t.Errorf("BattDate in %v = %v, want %v", loc, got.BattDate, want)
+			}
+			if want := got.LastOnBattery.In(loc).Format(timeFormat); got.LastOutage != want {
+				t.Errorf("LastOutage in %v = %q, want %q", loc, got.LastOutage, want)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// deadlineDialer refuses every connection, recording how long each
+// dial had until its deadline.
+type deadlineDialer struct {
+	mu   sync.Mutex
+	left []time.Duration
+}
+
+func (d *deadlineDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	deadline, ok := ctx.Deadline()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if ok {
+		d.left = append(d.left, time.Until(deadline))
+	} else {
+		d.left = append(d.left, -1)
+	}
+	return nil, errors.New("connection refused")
+}
+
+func TestWithDialTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		timeout, want time.Duration
+	}{
+		{0, DialDuration},
+		{-time.Second, DialDuration},
+		{time.Second, time.Second},
+		{time.Minute, time.Minute},
+	} {
+		if got := newConfig([]Option{WithDialTimeout(tc.timeout)}).dialTimeout; got != tc.want {
+			t.Errorf("WithDialTimeout(%v) sets %v, want %v", tc.timeout, got, tc.want)
+		}
+		d := &deadlineDialer{}
+		if _, err := Query(context.Background(), "10.0.0.1", WithDialer(d), WithDialTimeout(tc.timeout)); !errors.Is(err, ErrDial) {
+			t.Errorf("WithDialTimeout(%v): Query = %v, want an ErrDial", tc.timeout, err)
+		}
+		if len(d.left) != 1 || d.left[0] > tc.want || d.left[0] < tc.want-time.Second/2 {
+			t.Errorf("WithDialTimeout(%v) dialed with %v left, want %v", tc.timeout, d.left, tc.want)
+		}
+	}
+}
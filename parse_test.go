@@ -0,0 +1,568 @@
+package apcupsc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// goldenDumps returns the names of the captured apcaccess dumps in
+// testdata, without their .txt suffix.
+func goldenDumps(tb testing.TB) []string {
+	tb.Helper()
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.txt"))
+	if err != nil || len(paths) == 0 {
+		tb.Fatalf("no dumps in testdata: %v", err)
+	}
+	var names []string
+	for _, p := range paths {
+		names = append(names, strings.TrimSuffix(filepath.Base(p), ".txt"))
+	}
+	return names
+}
+
+// TestGolden parses each dump in testdata, as framed by the network
+// protocol, as plain apcaccess text and as that text with its keys
+// trimmed and blank lines between them, and compares the JSON encoding
+// of the result with the dump's .golden file. Run with
+// -update to regenerate the golden files after an intended change.
+func TestGolden(t *testing.T) {
+	for _, name := range goldenDumps(t) {
+		t.Run(name, func(t *testing.T) {
+			dump, err := os.ReadFile(filepath.Join("testdata", name+".txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			framed, err := ParseStatus(bytes.NewReader(frameLines(string(dump))), WithLocation(time.UTC))
+			if err != nil {
+				t.Fatalf("ParseStatus: %v", err)
+			}
+			text, err := ParseStatusText(bytes.NewReader(dump), WithLocation(time.UTC))
+			if err != nil {
+				t.Fatalf("ParseStatusText: %v", err)
+			}
+			trimmed, err := ParseStatusText(strings.NewReader(trimKeys(string(dump))), WithLocation(time.UTC))
+			if err != nil {
+				t.Fatalf("ParseStatusText trimmed: %v", err)
+			}
+			got := goldenJSON(t, framed)
+			if fromText := goldenJSON(t, text); !bytes.Equal(got, fromText) {
+				t.Errorf("framed and text parses differ:\nframed: %s\ntext:   %s", got, fromText)
+			}
+			if fromTrimmed := goldenJSON(t, trimmed); !bytes.Equal(got, fromTrimmed) {
+				t.Errorf("framed and trimmed text parses differ:\nframed:  %s\ntrimmed: %s", got, fromTrimmed)
+			}
+			golden := filepath.Join("testdata", name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("parsed %s differs from %s:\ngot:  %s\nwant: %s", name, golden, got, want)
+			}
+		})
+	}
+}
+
+// trimKeys returns dump with the padding around its keys removed, each
+// line as "KEY: value", and a blank line after each, as apcupsd output
+// copied by hand or through other tools often is.
+func trimKeys(dump string) string {
+	var out strings.Builder
+	for _, l := range strings.Split(dump, "\n") {
+		if k, v, ok := strings.Cut(l, ":"); ok {
+			l = strings.TrimSpace(k) + ": " + strings.TrimSpace(v)
+		}
+		out.WriteString(l + "\n\n")
+	}
+	return out.String()
+}
+
+// goldenJSON returns the indented JSON encoding of t.
+func goldenJSON(tb testing.TB, t *Target) []byte {
+	tb.Helper()
+	b, err := t.MarshalJSON()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, b, "", "\t"); err != nil {
+		tb.Fatal(err)
+	}
+	out.WriteByte('\n')
+	return out.Bytes()
+}
+
+// TestParseStatusLongLines parses frames longer than the 4096 byte
+// buffer of a default bufio.Reader, both over the network and from a
+// reader, and frames as long as the protocol allows.
+func TestParseStatusLongLines(t *testing.T) {
+	long := strings.Repeat("x", 5000)
+	longest := strings.Repeat("y", 0xffff-len("MODEL    : \n"))
+	dump := withLine(withLine(testDump, "VERSION", long), "MODEL", longest)
+	check := func(what string, got *Target, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("%s: %v", what, err)
+		}
+		if got.Version != long {
+			t.Errorf("%s: Version has %d bytes, want %d", what, len(got.Version), len(long))
+		}
+		if got.Extra["MODEL"] != longest {
+			t.Errorf("%s: MODEL has %d bytes, want %d", what, len(got.Extra["MODEL"]), len(longest))
+		}
+		if got.Name != "myapc" || got.NomPower != 900 {
+			t.Errorf("%s: lines after the long ones were lost: Name %q NomPower %d", what, got.Name, got.NomPower)
+		}
+	}
+	got, err := ParseStatus(bytes.NewReader(frameLines(dump)), WithExtra())
+	check("ParseStatus", got, err)
+	addr, _ := startNIS(t, answer(dump))
+	got, err = Query(context.Background(), addr, WithExtra())
+	check("Query", got, err)
+}
+
+func TestParseStatusMalformed(t *testing.T) {
+	framed := frameLines(testDump)
+	for _, tc := range []struct {
+		name  string
+		input []byte
+		want  error
+	}{
+		{"empty", nil, ErrIncomplete},
+		{"header only", framed[:1], ErrTooShort},
+		{"truncated frame", framed[:40], ErrTooShort},
+		{"ends early", frameLines("APC      : 001,036,0879\nDATE     : 2024-10-19 11:46:30 -0700\n"), ErrIncomplete},
+		{"no END APC", framed[:len(framed)-60], ErrIncomplete},
+	} {
+		_, err := ParseStatus(bytes.NewReader(tc.input))
+		if !errors.Is(err, tc.want) || !errors.Is(err, ErrIncomplete) {
+			t.Errorf("%s: got %v, want %v wrapping ErrIncomplete", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestParseStatusIgnoresBadValues(t *testing.T) {
+	dump := testDump
+	for key, value := range map[string]string{
+		"LINEV":    "lots Volts",
+		"LOADPCT":  "5.0 Watts",
+		"TIMELEFT": "103.2",
+		"NUMXFERS": "one",
+		"DATE":     "yesterday",
+		"SELFTEST": "",
+	} {
+		dump = withLine(dump, key, value)
+	}
+	dump += "SHORT\n"
+	got, err := ParseStatus(bytes.NewReader(frameLines(dump)))
+	if err != nil {
+		t.Fatalf("ParseStatus: %v", err)
+	}
+	for _, field := range []string{"LineV", "LoadPct", "TimeLeft", "XFers", "SampledAt", "Power", "Charge"} {
+		if got.Reported(field) {
+			t.Errorf("%s was Reported from a malformed value", field)
+		}
+	}
+	if !got.Reported("BCharge") || got.BCharge != 100 {
+		t.Errorf("BCharge=%v: the well formed lines should still be parsed", got.BCharge)
+	}
+}
+
+// TestOutage checks how the XONBATT and XOFFBATT timestamps are read
+// as a completed outage, one in progress, or none since a restart.
+func TestOutage(t *testing.T) {
+	offFirst := strings.Replace(withoutLine(testDump, "XOFFBATT"),
+		"XONBATT", statusLine("XOFFBATT", "2024-10-03 03:11:12 -0700")+"\nXONBATT", 1)
+	tests := []struct {
+		name       string
+		dump       string
+		inProgress bool
+		// noOutage is true when OutageInProgress is not Reported.
+		noOutage bool
+		// lasted is the Lasted that should be Reported, if any.
+		lasted time.Duration
+	}{
+		{name: "completed", dump: testDump, lasted: 2 * time.Second},
+		{name: "completed XOFFBATT first", dump: offFirst, lasted: 2 * time.Second},
+		{name: "completed long", dump: withLine(testDump, "XOFFBATT", "2024-10-03 05:41:10 -0700"), lasted: 150 * time.Minute},
+		{name: "restart", dump: withLine(testDump, "XONBATT", "N/A"), noOutage: true},
+		{name: "restart without XONBATT", dump: withoutLine(testDump, "XONBATT"), noOutage: true},
+		{name: "restart without either", dump: withoutLine(withoutLine(testDump, "XONBATT"), "XOFFBATT"), noOutage: true},
+		{name: "in progress", dump: withLine(testDump, "XOFFBATT", "N/A"), inProgress: true},
+		{name: "in progress without XOFFBATT", dump: withoutLine(testDump, "XOFFBATT"), inProgress: true},
+		{name: "in progress after an earlier outage", dump: withLine(testDump, "XOFFBATT", "2024-09-30 08:00:00 -0700"), inProgress: true},
+		{name: "multi-year", dump: withLine(testDump, "XOFFBATT", "2027-10-03 03:11:12 -0700")},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseStatusText(strings.NewReader(tc.dump))
+			if err != nil {
+				t.Fatalf("ParseStatusText: %v", err)
+			}
+			if got.Reported("OutageInProgress") == tc.noOutage || got.OutageInProgress != tc.inProgress {
+				t.Errorf("OutageInProgress = %v (reported %v), want %v (reported %v)",
+					got.OutageInProgress, got.Reported("OutageInProgress"), tc.inProgress, !tc.noOutage)
+			}
+			if got.Reported("Lasted") != (tc.lasted != 0) || got.Lasted != tc.lasted {
+				t.Errorf("Lasted = %v (reported %v), want %v (reported %v)",
+					got.Lasted, got.Reported("Lasted"), tc.lasted, tc.lasted != 0)
+			}
+			if got.Lasted < 0 {
+				t.Errorf("Lasted is negative: %v", got.Lasted)
+			}
+		})
+	}
+}
+
+// TestOnBattery checks that the fields derived from the outage dumps
+// in testdata describe a UPS running on its battery.
+func TestOnBattery(t *testing.T) {
+	const capacity = 187 // Wh, of a Back-UPS XS 1500M
+	for _, tc := range []struct {
+		name   string
+		frozen bool
+	}{
+		{"backups-xs1500m-onbatt", false},
+		{"backups-xs1500m-onbatt-frozen", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dump, err := os.ReadFile(filepath.Join("testdata", tc.name+".txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ParseStatusText(bytes.NewReader(dump))
+			if err != nil {
+				t.Fatalf("ParseStatusText: %v", err)
+			}
+			if !got.Offline || !got.OutageInProgress || got.Reported("Lasted") {
+				t.Errorf("Offline=%v OutageInProgress=%v Lasted reported=%v, want an outage in progress",
+					got.Offline, got.OutageInProgress, got.Reported("Lasted"))
+			}
+			if want := int(got.TimeLeft / time.Minute); got.Backup != want {
+				t.Errorf("Backup is %d, want the %d whole minutes of TimeLeft %v", got.Backup, want, got.TimeLeft)
+			}
+			if tc.frozen {
+				if got.Reported("Power") || got.Reported("Charge") {
+					t.Errorf("Power %d and Charge %d are derived from a frozen LOADPCT", got.Power, got.Charge)
+				}
+			} else if !got.Reported("Power") || got.Power <= 0 || got.Power > got.NomPower {
+				t.Errorf("Power is %d (reported %v), want a draw within NomPower %d", got.Power, got.Reported("Power"), got.NomPower)
+			}
+
+			got, err = ParseStatusText(bytes.NewReader(dump), WithBatteryCapacity(capacity))
+			if err != nil {
+				t.Fatalf("ParseStatusText: %v", err)
+			}
+			if want := int(capacity * got.BCharge / 100); !got.Reported("Charge") || got.Charge != want {
+				t.Errorf("Charge is %d (reported %v), want %d Wh of BCharge %v", got.Charge, got.Reported("Charge"), want, got.BCharge)
+			}
+			if got.Reported("Power") == tc.frozen {
+				t.Errorf("Power reported %v with a frozen LOADPCT %v", got.Reported("Power"), tc.frozen)
+			}
+		})
+	}
+}
+
+// TestApparentPower checks ApparentPowerVA against the output voltage
+// and current of the dumps in testdata, and that it is 0 for a UPS
+// that does not report its output current.
+func TestApparentPower(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want float64
+	}{
+		{"smartups-srt3000", 230.0 * 3.91},
+		{"smartups-1500", 0},
+		{"backups-xs1500m", 0},
+	} {
+		dump, err := os.ReadFile(filepath.Join("testdata", tc.name+".txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ParseStatusText(bytes.NewReader(dump))
+		if err != nil {
+			t.Fatalf("%s: ParseStatusText: %v", tc.name, err)
+		}
+		if va := got.ApparentPowerVA(); math.Abs(va-tc.want) > 1e-9 {
+			t.Errorf("%s: ApparentPowerVA() = %v, want %v", tc.name, va, tc.want)
+		}
+	}
+}
+
+func TestParseNumbers(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		number  float64
+		integer int
+		// bad lists which of parseNumber ("n") and parseInteger ("i")
+		// reject in.
+		bad string
+	}{
+		{in: "120", number: 120, integer: 120},
+		{in: "-3", number: -3, integer: -3},
+		{in: "231.5", number: 231.5, bad: "i"},
+		{in: "231,5", number: 231.5, bad: "i"},
+		{in: "900.0", number: 900, integer: 900},
+		{in: "1.234,5", number: 1234.5, bad: "i"},
+		{in: "1,234.5", number: 1234.5, bad: "i"},
+		// A lone comma is a decimal separator, but a whole value
+		// can only have grouped thousands.
+		{in: "1,234", number: 1.234, integer: 1234},
+		{in: "1.500", number: 1.5, integer: 1500},
+		{in: "-1.500", number: -1.5, integer: -1500},
+		{in: "12,345,678", number: 12345678, integer: 12345678},
+		{in: "12.345.678", integer: 12345678, bad: "n"},
+		{in: "1,23", number: 1.23, bad: "i"},
+		{in: "1.2.3", bad: "ni"},
+		{in: "", bad: "ni"},
+		{in: "one", bad: "ni"},
+	} {
+		n, err := parseNumber(tc.in)
+		if bad := strings.Contains(tc.bad, "n"); (err != nil) != bad || !bad && n != tc.number {
+			t.Errorf("parseNumber(%q) = %v, %v, want %v (failing: %v)", tc.in, n, err, tc.number, bad)
+		}
+		i, err := parseInteger(tc.in)
+		if bad := strings.Contains(tc.bad, "i"); (err != nil) != bad || !bad && i != tc.integer {
+			t.Errorf("parseInteger(%q) = %v, %v, want %v (failing: %v)", tc.in, i, err, tc.integer, bad)
+		}
+	}
+}
+
+// TestStrictNumbers parses the comma-decimal dump in testdata with and
+// without WithStrictNumbers.
+func TestStrictNumbers(t *testing.T) {
+	dump, err := os.ReadFile(filepath.Join("testdata", "smartups-x2200-comma.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	localized := map[string]any{
+		"LineV":         231.5,
+		"LoadPct":       12.5,
+		"BCharge":       98.0,
+		"TimeLeft":      41*time.Minute + 18*time.Second,
+		"LowTransferV":  176.0,
+		"HighTransferV": 282.0,
+		"XFers":         1024,
+		"NomPower":      1980,
+	}
+	lenient := parseDump(t, string(dump))
+	strict, err := ParseStatus(bytes.NewReader(frameLines(string(dump))), WithStrictNumbers())
+	if err != nil {
+		t.Fatalf("ParseStatus: %v", err)
+	}
+	for field, want := range localized {
+		if got := reflect.ValueOf(lenient).Elem().FieldByName(field).Interface(); !lenient.Reported(field) || got != want {
+			t.Errorf("%s = %v (reported %v), want %v", field, got, lenient.Reported(field), want)
+		}
+		if strict.Reported(field) || strict.NotAvailable(field) {
+			t.Errorf("WithStrictNumbers: localized %s reported as %v", field, reflect.ValueOf(strict).Elem().FieldByName(field))
+		}
+	}
+	// Plain values are parsed as usual.
+	for _, field := range []string{"MinBCharge", "MinTimeLeft", "MaxTime", "AlarmDelay", "Name", "LastOnBattery"} {
+		if !strict.Reported(field) {
+			t.Errorf("WithStrictNumbers: plain %s not reported", field)
+		}
+	}
+	if strict.Reported("Power") || strict.Reported("Charge") {
+		t.Errorf("WithStrictNumbers: Power %d and Charge %d derived from rejected values", strict.Power, strict.Charge)
+	}
+
+	// A dump of plain values parses the same either way, and N/A is
+	// not a failure.
+	if diffs := diffFields(parseDump(t, testDump), parseDump(t, testDump, WithStrictNumbers())); len(diffs) != 0 {
+		t.Errorf("WithStrictNumbers changed a plain dump: %v", diffs)
+	}
+	na, err := os.ReadFile(filepath.Join("testdata", "backups-es700-na.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := parseDump(t, string(na), WithStrictNumbers()); !got.NotAvailable("NomPower") || !got.NotAvailable("MaxTime") {
+		t.Errorf("WithStrictNumbers: N/A NomPower %v MaxTime %v, want NotAvailable", got.NotAvailable("NomPower"), got.NotAvailable("MaxTime"))
+	}
+}
+
+func TestParseAPCTime(t *testing.T) {
+	la, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdt := time.Date(2024, 10, 21, 18, 55, 40, 0, time.FixedZone("", -7*3600))
+	for _, tc := range []struct {
+		in   string
+		loc  *time.Location
+		want time.Time
+		err  bool
+	}{
+		{in: "2024-10-21 18:55:40 -0700", loc: berlin, want: pdt},
+		{in: " 2024-10-21 18:55:40 -0700  ", loc: nil, want: pdt},
+		{in: "2024-10-21 18:55:40", loc: la, want: pdt},
+		{in: "Mon Oct 21 18:55:40 PDT 2024", loc: la, want: pdt},
+		{in: "Mon Jan 06 10:00:00 PST 2025", loc: la, want: time.Date(2025, 1, 6, 18, 0, 0, 0, time.UTC)},
+		{in: "Mon Oct 21 18:55:40 CEST 2024", loc: berlin, want: pdt.Add(-9 * time.Hour)},
+		{in: "Mon Oct 21 18:55:40 UTC 2024", loc: la, want: pdt.Add(-7 * time.Hour)},
+		{in: "Mon Oct 21 18:55:40 GMT 2024", loc: berlin, want: pdt.Add(-7 * time.Hour)},
+		// An abbreviation the location does not use has no known
+		// offset.
+		{in: "Mon Oct 21 18:55:40 CEST 2024", loc: la, err: true},
+		{in: "Mon Oct 21 18:55:40 PDT 2024", loc: berlin, err: true},
+		{in: "Mon Oct 21 18:55:40 XYZ 2024", loc: la, err: true},
+		{in: "yesterday", loc: la, err: true},
+		{in: "", loc: la, err: true},
+	} {
+		got, err := ParseAPCTime(tc.in, tc.loc)
+		if (err != nil) != tc.err || !got.Equal(tc.want) {
+			t.Errorf("ParseAPCTime(%q, %v) = %v, %v, want %v (failing: %v)", tc.in, tc.loc, got, err, tc.want, tc.err)
+		}
+	}
+	if _, err := ParseAPCTime(" N/A ", la); !errors.Is(err, ErrNotAvailable) {
+		t.Errorf("ParseAPCTime(N/A) returned %v, want ErrNotAvailable", err)
+	}
+}
+
+func TestParseAPCTimeDefault(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(loc *time.Location) { TimeLocation = loc }(TimeLocation)
+	TimeLocation = tokyo
+	want := time.Date(2024, 10, 21, 18, 55, 40, 0, tokyo)
+	for _, in := range []string{"2024-10-21 18:55:40", "Mon Oct 21 18:55:40 JST 2024"} {
+		if got, err := ParseAPCTime(in, nil); err != nil || !got.Equal(want) {
+			t.Errorf("ParseAPCTime(%q, nil) = %v, %v, want %v in TimeLocation", in, got, err, want)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		want  time.Duration
+		err   bool
+	}{
+		{value: "30 Seconds", want: 30 * time.Second},
+		{value: "1 Second", want: time.Second},
+		{value: "103.2 Minutes", want: 103*time.Minute + 12*time.Second},
+		{value: "1 Minute", want: time.Minute},
+		{value: "1.2 Hours", want: 72 * time.Minute},
+		{value: "1 hour", want: time.Hour},
+		{value: "2 Days", want: 48 * time.Hour},
+		{value: "1 DAY", want: 24 * time.Hour},
+		{value: "0,5 minutes", want: 30 * time.Second},
+		{value: "1.5   Minutes", want: 90 * time.Second},
+		{value: "  45\tseconds ", want: 45 * time.Second},
+		{value: "-5 Seconds", want: -5 * time.Second},
+		{value: "5 Weeks", err: true},
+		{value: "5 Min", err: true},
+		{value: "5", err: true},
+		{value: "Minutes", err: true},
+		{value: "5 Minutes left", err: true},
+		{value: "1e300 Days", err: true},
+		{value: "NaN Seconds", err: true},
+		{value: "Inf Seconds", err: true},
+	} {
+		got, err := digestDuration(tc.value)
+		if (err != nil) != tc.err || !tc.err && got != tc.want {
+			t.Errorf("digestDuration(%q) = %v, %v, want %v (failing: %v)", tc.value, got, err, tc.want, tc.err)
+		}
+	}
+	if _, err := ParseDuration("N/A", "Minutes"); !errors.Is(err, ErrNotAvailable) {
+		t.Errorf("ParseDuration(N/A) returned %v, want ErrNotAvailable", err)
+	}
+	if got, err := ParseDuration(" 2 ", " Hours "); err != nil || got != 2*time.Hour {
+		t.Errorf("ParseDuration(2, Hours) = %v, %v, want 2h", got, err)
+	}
+}
+
+func FuzzParseAPCTime(f *testing.F) {
+	for _, s := range []string{
+		"2024-10-19 11:46:30 -0700",
+		"2024-10-19 11:46:30",
+		"Sat Oct 19 11:46:30 PDT 2024",
+		"Sat Oct 19 11:46:30 GMT+3 2024",
+		"N/A",
+		"",
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		got, err := ParseAPCTime(s, time.UTC)
+		if err != nil {
+			return
+		}
+		// A parsed time formats back to one that parses to the
+		// same instant.
+		again, err := ParseAPCTime(formatTime(got, time.UTC), time.UTC)
+		if err != nil || !again.Equal(got) {
+			t.Errorf("ParseAPCTime(%q) = %v, which reparses as %v, %v", s, got, again, err)
+		}
+	})
+}
+
+func FuzzParseDuration(f *testing.F) {
+	for _, s := range [][2]string{
+		{"103.2", "Minutes"},
+		{"30", "Seconds"},
+		{"1,5", "hour"},
+		{"N/A", "Minutes"},
+		{"1e300", "days"},
+		{"-0", "Seconds"},
+		{"NaN", "Seconds"},
+	} {
+		f.Add(s[0], s[1])
+	}
+	f.Fuzz(func(t *testing.T, value, unit string) {
+		d, err := ParseDuration(value, unit)
+		if err != nil {
+			if d != 0 {
+				t.Errorf("ParseDuration(%q, %q) = %v with error %v", value, unit, d, err)
+			}
+			return
+		}
+		// The duration is the value in the unit, to within the
+		// rounding of converting it to nanoseconds.
+		seconds, err := ParseDuration("1", unit)
+		if err != nil {
+			t.Fatalf("ParseDuration(1, %q) failed after accepting %q: %v", unit, value, err)
+		}
+		f, _ := parseNumber(strings.TrimSpace(value))
+		if want := f * float64(seconds); math.Abs(float64(d)-want) > 1+math.Abs(want)*1e-15 {
+			t.Errorf("ParseDuration(%q, %q) = %v, want %v", value, unit, d, time.Duration(want))
+		}
+	})
+}
+
+func BenchmarkParseStatus(b *testing.B) {
+	framed := frameLines(testDump)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(framed)))
+	for range b.N {
+		if _, err := ParseStatus(bytes.NewReader(framed)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package apcupsc
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// pollTarget is a StatusSource watched by a Poller, with the options
+// that apply to it alone.
+type pollTarget struct {
+	src  StatusSource
+	opts []Option
+	done func()
+}
+
+// Poller watches several apcupsd services, merging their Events onto
+// one channel. To avoid dialing every service at once, the first
+// polls are staggered across each service's poll interval, evenly in
+// the order the services were added or, with WithHashedStagger, by a
+// hash of each address. Adding WithPollJitter keeps the polls apart
+// as they run.
+type Poller struct {
+	opts []Option
+
+	mu      sync.Mutex
+	targets []pollTarget
+	status  []PollStatus
+}
+
+// PollStatus is the most recent outcome of polling one of the
+// services watched by a Poller.
+type PollStatus struct {
+	// Addr is the address of the service.
+	Addr string
+	// Target is the most recent successful sample, and SampledAt
+	// when it was taken. Target is nil until a poll succeeds.
+	Target    *Target
+	SampledAt time.Time
+	// Err is the error of the most recent poll, or nil if it
+	// succeeded.
+	Err error
+	// Errors counts the polls that have failed.
+	Errors int
+}
+
+// Age returns how old the most recent successful sample is at now,
+// or 0 if there is none.
+func (s PollStatus) Age(now time.Time) time.Duration {
+	if s.Target == nil {
+		return 0
+	}
+	return now.Sub(s.SampledAt)
+}
+
+// NewPoller returns a Poller whose opts apply to every service it
+// watches. A sink passed here sees the samples of every service, so
+// WithHistory and WithEnergyMeter, which assume a single UPS, belong
+// in the opts of Add instead; WithRuntimeTracker and WithSink, which
+// are given the address of each sample, suit either.
+func NewPoller(opts ...Option) *Poller {
+	return &Poller{opts: opts}
+}
+
+// Add watches the apcupsd service at ep with a Client. The opts
+// override the Poller's options for this service alone, for example
+// WithPollInterval to poll it more or less often than the others.
+func (p *Poller) Add(ep string, opts ...Option) {
+	opts = append(append([]Option{}, p.opts...), opts...)
+	c := NewClient(ep, opts...)
+	p.add(pollTarget{src: c, opts: opts, done: func() { c.Close() }})
+}
+
+// AddSource watches src. The opts are as for Add.
+func (p *Poller) AddSource(src StatusSource, opts ...Option) {
+	opts = append(append([]Option{}, p.opts...), opts...)
+	p.add(pollTarget{src: src, opts: opts, done: func() {}})
+}
+
+// add records a target to be watched by Run.
+func (p *Poller) add(t pollTarget) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.targets = append(p.targets, t)
+	p.status = append(p.status, PollStatus{Addr: t.src.Addr()})
+}
+
+// Snapshot returns the most recent outcome of polling each service,
+// in the order they were added, without waiting for any poll. This
+// suits callers, such as metrics exporters, that must answer
+// promptly however slow the services are. The Targets are shared and
+// must not be modified.
+func (p *Poller) Snapshot() []PollStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]PollStatus{}, p.status...)
+}
+
+// record notes the outcome of a poll of the i'th service.
+func (p *Poller) record(i int, t *Target, err error, at time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := &p.status[i]
+	s.Err = err
+	if err != nil {
+		s.Errors++
+		return
+	}
+	s.Target, s.SampledAt = t, at
+}
+
+// Run watches every service added to the Poller, as for Watch, until
+// ctx is cancelled, sending their Events on the returned channel. The
+// channel is closed once every watch has stopped. Services added
+// after Run is called are not watched by it.
+func (p *Poller) Run(ctx context.Context) <-chan Event {
+	p.mu.Lock()
+	targets := append([]pollTarget{}, p.targets...)
+	p.mu.Unlock()
+
+	out := make(chan Event)
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		cfg := newConfig(t.opts)
+		cfg.startDelay = cfg.stagger(t.src.Addr(), i, len(targets))
+		cfg.polled = func(t *Target, err error, at time.Time) {
+			p.record(i, t, err, at)
+		}
+		wg.Add(1)
+		go func(events <-chan Event) {
+			defer wg.Done()
+			for e := range events {
+				select {
+				case out <- e:
+				case <-ctx.Done():
+				}
+			}
+		}(cfg.watch(ctx, t.src, t.done))
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// stagger returns the delay before the first poll of the i'th of n
+// services, which has the address addr.
+func (cfg *config) stagger(addr string, i, n int) time.Duration {
+	if cfg.hashStagger {
+		h := fnv.New64a()
+		h.Write([]byte(addr))
+		return time.Duration(h.Sum64() % uint64(cfg.interval))
+	}
+	return cfg.interval * time.Duration(i) / time.Duration(n)
+}
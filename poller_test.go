@@ -0,0 +1,147 @@
+package apcupsc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestPollerStagger plays the schedule of the polls a Poller makes of
+// 300 services, with a mix of poll intervals, on a virtual clock, and
+// checks that no more than a few polls fall within any 10ms.
+func TestPollerStagger(t *testing.T) {
+	const (
+		n       = 300
+		horizon = 2 * time.Minute
+		window  = 10 * time.Millisecond
+		most    = 3
+	)
+	addr := func(i int) string { return fmt.Sprintf("10.0.%d.%d:3551", i/256, i%256) }
+	for _, tc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"even", nil},
+		{"hashed", []Option{WithHashedStagger()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var fires []time.Duration
+			for i := range n {
+				opts := append([]Option{WithPollInterval(10 * time.Second)}, tc.opts...)
+				switch i % 3 {
+				case 1:
+					opts = append(opts, WithPollInterval(5*time.Second))
+				case 2:
+					opts = append(opts, WithPollInterval(time.Minute))
+				}
+				cfg := newConfig(opts)
+				start := cfg.stagger(addr(i), i, n)
+				if start < 0 || start >= cfg.interval {
+					t.Fatalf("%s starts after %v, outside its %v interval", addr(i), start, cfg.interval)
+				}
+				for at := start; at < horizon; at += cfg.interval {
+					fires = append(fires, at)
+				}
+			}
+			slices.Sort(fires)
+			for i, j := 0, 0; i < len(fires); i++ {
+				for fires[i]-fires[j] >= window {
+					j++
+				}
+				if i-j+1 > most {
+					t.Fatalf("%d polls within %v of %v, want at most %d", i-j+1, window, fires[j], most)
+				}
+			}
+		})
+	}
+}
+
+func TestHashedStaggerStable(t *testing.T) {
+	cfg := newConfig([]Option{WithHashedStagger()})
+	for i := range 10 {
+		a := cfg.stagger(fmt.Sprintf("ups%d:3551", i), i, 10)
+		if b := cfg.stagger(fmt.Sprintf("ups%d:3551", i), 9-i, 20); a != b {
+			t.Errorf("ups%d starts after %v or %v, depending on the other services", i, a, b)
+		}
+	}
+}
+
+// heldSource is a stubSource that, once it has returned limit
+// results, blocks each further call until its ctx is done.
+type heldSource struct {
+	*stubSource
+	limit int
+}
+
+func (s *heldSource) Status(ctx context.Context) (*Target, error) {
+	s.mu.Lock()
+	n := s.calls
+	s.mu.Unlock()
+	if n >= s.limit {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return s.stubSource.Status(ctx)
+}
+
+func TestPollerSnapshot(t *testing.T) {
+	down := errors.New("upstream down")
+	sample := parseDump(t, testDump)
+	good := &heldSource{&stubSource{addr: "good:3551", results: []stubResult{{t: sample}}}, 2}
+	// flaky succeeds once and then fails three times.
+	flaky := &heldSource{&stubSource{addr: "flaky:3551", results: []stubResult{{t: sample}, {err: down}}}, 4}
+	p := NewPoller(WithPollInterval(time.Millisecond), WithMaxBackoff(time.Millisecond))
+	p.AddSource(good)
+	p.AddSource(flaky)
+
+	now := time.Now()
+	before := p.Snapshot()
+	if len(before) != 2 || before[0].Addr != "good:3551" || before[1].Addr != "flaky:3551" {
+		t.Fatalf("Snapshot before Run = %+v, want good and flaky in order", before)
+	}
+	for _, s := range before {
+		if s.Target != nil || !s.SampledAt.IsZero() || s.Err != nil || s.Errors != 0 || s.Age(now) != 0 {
+			t.Errorf("%s: Snapshot before the first poll = %+v, age %v, want nothing", s.Addr, s, s.Age(now))
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := p.Run(ctx)
+	go func() {
+		for range events {
+		}
+	}()
+	waitFor(t, "the polls", func() bool {
+		s := p.Snapshot()
+		return s[0].Target != nil && s[1].Errors == 3
+	})
+
+	got := p.Snapshot()
+	if s := got[0]; s.Err != nil || s.Errors != 0 || s.Target.Name != "myapc" {
+		t.Errorf("good: Snapshot = %+v, want its sample and no errors", s)
+	}
+	s := got[1]
+	if !errors.Is(s.Err, down) || s.Errors != 3 {
+		t.Errorf("flaky: Err %v Errors %d, want %v after 3 failed polls", s.Err, s.Errors, down)
+	}
+	// The sample taken before the failures is still reported, and
+	// ages.
+	if s.Target == nil || s.Target.Name != "myapc" || s.SampledAt.Before(now) {
+		t.Fatalf("flaky: Snapshot = %+v, want the sample taken before the failures", s)
+	}
+	if age := s.Age(s.SampledAt.Add(time.Minute)); age != time.Minute {
+		t.Errorf("flaky: Age a minute after the sample = %v", age)
+	}
+	later := time.Now().Add(time.Hour)
+	if age := s.Age(later); age != later.Sub(s.SampledAt) || age < time.Hour {
+		t.Errorf("flaky: Age an hour on = %v, want %v", age, later.Sub(s.SampledAt))
+	}
+	time.Sleep(5 * time.Millisecond)
+	if again := p.Snapshot()[1]; !again.SampledAt.Equal(s.SampledAt) || again.Target != s.Target || again.Errors != 3 {
+		t.Errorf("flaky: a later Snapshot = %+v, want the same stale sample", again)
+	}
+}
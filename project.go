@@ -0,0 +1,55 @@
+package apcupsc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrCannotProject is wrapped by the errors returned by
+// ProjectRuntime.
+var ErrCannotProject = errors.New("cannot project runtime")
+
+// ProjectRuntime estimates the TimeLeft the UPS would report if its
+// load grew by additionalWatts, which may be negative to model
+// removing equipment. When Charge is reported, typically from the
+// capacity given by WithBatteryCapacity, the estimate is the time
+// that energy lasts at the projected load. Otherwise TimeLeft is
+// scaled by the ratio of the current load to the projected one. Both
+// assume the battery delivers the same energy regardless of the rate
+// it is drawn at. Real batteries deliver less energy at higher loads,
+// so the estimate is optimistic when adding load and pessimistic when
+// removing it. An error is returned when LoadPct or NomPower were not
+// reported, the load is not known because LOADPCT froze on battery,
+// the projected load is not positive or would exceed NomPower, or
+// neither Charge nor TimeLeft at a non-zero current load allow an
+// estimate.
+func (t *Target) ProjectRuntime(additionalWatts float64) (time.Duration, error) {
+	for _, field := range []string{"LoadPct", "NomPower"} {
+		if !t.Reported(field) {
+			return 0, fmt.Errorf("%w: %s not reported", ErrCannotProject, field)
+		}
+	}
+	// Power is left unreported when LOADPCT cannot be trusted.
+	if !t.Reported("Power") {
+		return 0, fmt.Errorf("%w: current load not known", ErrCannotProject)
+	}
+	watts := float64(t.NomPower) * t.LoadPct / 100
+	projected := watts + additionalWatts
+	if projected <= 0 {
+		return 0, fmt.Errorf("%w: projected load %vW is not positive", ErrCannotProject, projected)
+	}
+	if projected > float64(t.NomPower) {
+		return 0, fmt.Errorf("%w: projected load %vW exceeds %dW", ErrCannotProject, projected, t.NomPower)
+	}
+	if t.Reported("Charge") && t.Charge > 0 {
+		return time.Duration(float64(t.Charge) / projected * float64(time.Hour)), nil
+	}
+	if !t.Reported("TimeLeft") {
+		return 0, fmt.Errorf("%w: neither Charge nor TimeLeft reported", ErrCannotProject)
+	}
+	if watts <= 0 {
+		return 0, fmt.Errorf("%w: no current load", ErrCannotProject)
+	}
+	return time.Duration(float64(t.TimeLeft) * watts / projected), nil
+}
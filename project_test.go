@@ -0,0 +1,121 @@
+package apcupsc
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestProjectRuntime(t *testing.T) {
+	// testDump draws 5% of 900W, 45W, for 103.2 minutes, from which
+	// Charge is derived as 77 Wh. With WithBatteryCapacity(187) and
+	// BCHARGE 100%, Charge is 187 Wh instead.
+	const capacity = 187
+	fallback := &Target{NomPower: 900, LoadPct: 10, TimeLeft: time.Hour}
+	for _, tc := range []struct {
+		name  string
+		t     *Target
+		watts float64
+		// want is the projection, or 0 when none can be made.
+		want time.Duration
+	}{
+		{
+			name:  "charge",
+			t:     parseDump(t, testDump),
+			watts: 150,
+			// 77 Wh / 195 W = 0.39487 h.
+			want: 23*time.Minute + 41538*time.Millisecond,
+		},
+		{
+			name: "capacity at the nominal load",
+			t:    parseDump(t, testDump, WithBatteryCapacity(capacity)),
+			// 187 Wh / 45 W = 4.1556 h.
+			want: 4*time.Hour + 9*time.Minute + 20*time.Second,
+		},
+		{
+			name:  "capacity adding load",
+			t:     parseDump(t, testDump, WithBatteryCapacity(capacity)),
+			watts: 150,
+			// 187 Wh / 195 W = 0.95897 h.
+			want: 57*time.Minute + 32308*time.Millisecond,
+		},
+		{
+			name:  "capacity removing load",
+			t:     parseDump(t, testDump, WithBatteryCapacity(capacity)),
+			watts: -15,
+			// 187 Wh / 30 W = 6.2333 h.
+			want: 6*time.Hour + 14*time.Minute,
+		},
+		{
+			name:  "capacity at zero load",
+			t:     parseDump(t, withLine(testDump, "LOADPCT", "0.0 Percent"), WithBatteryCapacity(capacity)),
+			watts: 100,
+			// 187 Wh / 100 W = 1.87 h.
+			want: time.Hour + 52*time.Minute + 12*time.Second,
+		},
+		{
+			name:  "zero load",
+			t:     parseDump(t, withLine(testDump, "LOADPCT", "0.0 Percent")),
+			watts: 100,
+		},
+		{
+			// 60 min * 90 W / 180 W.
+			name:  "runtime adding load",
+			t:     fallback,
+			watts: 90,
+			want:  30 * time.Minute,
+		},
+		{
+			// 60 min * 90 W / 60 W.
+			name:  "runtime removing load",
+			t:     fallback,
+			watts: -30,
+			want:  90 * time.Minute,
+		},
+		{
+			name:  "removing all load",
+			t:     parseDump(t, testDump, WithBatteryCapacity(capacity)),
+			watts: -45,
+		},
+		{
+			name:  "above NomPower",
+			t:     parseDump(t, testDump, WithBatteryCapacity(capacity)),
+			watts: 900,
+		},
+		{
+			name: "load above 100%",
+			t:    parseDump(t, withLine(testDump, "LOADPCT", "110.0 Percent"), WithBatteryCapacity(capacity)),
+		},
+		{
+			name:  "unknown NomPower",
+			t:     parseDump(t, withoutLine(testDump, "NOMPOWER"), WithBatteryCapacity(capacity)),
+			watts: 150,
+		},
+		{
+			name:  "NomPower not available",
+			t:     parseDump(t, withLine(testDump, "NOMPOWER", "N/A"), WithBatteryCapacity(capacity)),
+			watts: 150,
+		},
+		{
+			name: "frozen LOADPCT",
+			t: parseDump(t, withLine(withLine(testDump, "STATUS", "ONBATT"), "LOADPCT", "0.0 Percent"),
+				WithBatteryCapacity(capacity)),
+			watts: 150,
+		},
+	} {
+		got, err := tc.t.ProjectRuntime(tc.watts)
+		if tc.want == 0 {
+			if !errors.Is(err, ErrCannotProject) {
+				t.Errorf("%s: ProjectRuntime(%v) = %v, %v, want an error", tc.name, tc.watts, got, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: ProjectRuntime(%v): %v", tc.name, tc.watts, err)
+			continue
+		}
+		if d := got - tc.want; d < -time.Millisecond || d > time.Millisecond {
+			t.Errorf("%s: ProjectRuntime(%v) = %v, want %v", tc.name, tc.watts, got, tc.want)
+		}
+	}
+}
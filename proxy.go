@@ -0,0 +1,106 @@
+package apcupsc
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ProxyError is returned, wrapped in ErrDial, when an HTTP proxy set
+// with WithHTTPProxy refuses to connect to an apcupsd service, for
+// example with a StatusCode of 407 when the proxy requires other
+// credentials, or 502 when it could not reach the service.
+type ProxyError struct {
+	// Proxy is the host:port address of the proxy.
+	Proxy string
+	// StatusCode and Status are from the proxy's response.
+	StatusCode int
+	Status     string
+}
+
+// Error describes the proxy's refusal.
+func (e *ProxyError) Error() string {
+	return fmt.Sprintf("proxy %s refused CONNECT: %s", e.Proxy, e.Status)
+}
+
+// proxyDialer establishes connections through an HTTP proxy with the
+// CONNECT method.
+type proxyDialer struct {
+	proxy *url.URL
+	base  Dialer
+}
+
+// bufferedConn is a net.Conn whose reads first drain any bytes the
+// proxy sent after its response.
+type bufferedConn struct {
+	net.Conn
+	b *bufio.Reader
+}
+
+// Read reads from the buffered bytes, and then the connection.
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.b.Read(p)
+}
+
+// DialContext connects to the proxy and asks it to connect to addr.
+func (d *proxyDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.proxy.Scheme != "http" {
+		return nil, fmt.Errorf("proxy %s: unsupported scheme %q", d.proxy.Redacted(), d.proxy.Scheme)
+	}
+	proxy := d.proxy.Host
+	if d.proxy.Port() == "" {
+		proxy = net.JoinHostPort(d.proxy.Hostname(), "80")
+	}
+	c, err := d.base.DialContext(ctx, network, proxy)
+	if err != nil {
+		return nil, err
+	}
+	deadline, _ := ctx.Deadline()
+	c.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() {
+		c.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u := d.proxy.User; u != nil {
+		pass, _ := u.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(c); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("proxy %s: %w", proxy, err)
+	}
+	b := bufio.NewReader(c)
+	resp, err := http.ReadResponse(b, req)
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("proxy %s: %w", proxy, err)
+	}
+	// A successful response has no body: the tunnel follows it. The
+	// connection is closed on failure, so the body is not read.
+	if resp.StatusCode != http.StatusOK {
+		c.Close()
+		return nil, &ProxyError{Proxy: proxy, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	if !stop() {
+		c.Close()
+		return nil, ctx.Err()
+	}
+	c.SetDeadline(time.Time{})
+	if b.Buffered() > 0 {
+		return &bufferedConn{Conn: c, b: b}, nil
+	}
+	return c, nil
+}
@@ -0,0 +1,130 @@
+package apcupsc
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// startProxy starts an HTTP proxy, closed when the test ends, that
+// answers each CONNECT with an error status of refuse, or, when
+// refuse is zero, tunnels to the requested address. Requests without
+// the Basic credentials of user:secret are refused with 407. It
+// returns the proxy's URL, with those credentials, and the addresses
+// asked for.
+func startProxy(tb testing.TB, refuse int) (*url.URL, *atomic.Value) {
+	var asked atomic.Value
+	asked.Store([]string(nil))
+	addr, _ := listen(tb, func(c net.Conn) {
+		r := bufio.NewReader(c)
+		req, err := http.ReadRequest(r)
+		if err != nil {
+			return
+		}
+		asked.Store(append(asked.Load().([]string), req.Host))
+		user, pass, ok := (&http.Request{Header: http.Header{"Authorization": req.Header["Proxy-Authorization"]}}).BasicAuth()
+		status := refuse
+		if !ok || user != "user" || pass != "secret" {
+			status = http.StatusProxyAuthRequired
+		}
+		if req.Method != http.MethodConnect {
+			status = http.StatusMethodNotAllowed
+		}
+		var upstream net.Conn
+		if status == 0 {
+			if upstream, err = net.Dial("tcp", req.Host); err != nil {
+				status = http.StatusBadGateway
+			} else {
+				defer upstream.Close()
+				status = http.StatusOK
+			}
+		}
+		resp := &http.Response{StatusCode: status, ProtoMajor: 1, ProtoMinor: 1}
+		if err := resp.Write(c); err != nil || status != http.StatusOK {
+			return
+		}
+		go func() {
+			io.Copy(upstream, r)
+			upstream.Close()
+		}()
+		io.Copy(c, upstream)
+	})
+	return &url.URL{Scheme: "http", User: url.UserPassword("user", "secret"), Host: addr}, &asked
+}
+
+func TestHTTPProxy(t *testing.T) {
+	nis, _ := startNIS(t, answer(testDump))
+	ctx := context.Background()
+
+	t.Run("tunnel", func(t *testing.T) {
+		proxy, asked := startProxy(t, 0)
+		got, err := Query(ctx, nis, WithHTTPProxy(proxy))
+		if err != nil {
+			t.Fatalf("Query: %v", err)
+		}
+		if got.Name != "myapc" {
+			t.Errorf("Name is %q, want myapc", got.Name)
+		}
+		if a := asked.Load().([]string); len(a) != 1 || a[0] != nis {
+			t.Errorf("proxy was asked for %q, want %q", a, nis)
+		}
+	})
+
+	for _, tc := range []struct {
+		name   string
+		refuse int
+		user   *url.Userinfo
+	}{
+		{"unauthorized", 0, url.UserPassword("user", "wrong")},
+		{"no credentials", 0, nil},
+		{"bad gateway", http.StatusBadGateway, url.UserPassword("user", "secret")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			proxy, _ := startProxy(t, tc.refuse)
+			proxy.User = tc.user
+			want := tc.refuse
+			if want == 0 {
+				want = http.StatusProxyAuthRequired
+			}
+			_, err := Query(ctx, nis, WithHTTPProxy(proxy))
+			var pe *ProxyError
+			if !errors.Is(err, ErrDial) || !errors.As(err, &pe) || pe.StatusCode != want || pe.Proxy != proxy.Host {
+				t.Fatalf("Query returned %v, want a ProxyError with status %d from %s", err, want, proxy.Host)
+			}
+		})
+	}
+
+	t.Run("unreachable service", func(t *testing.T) {
+		proxy, _ := startProxy(t, 0)
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		gone := l.Addr().String()
+		l.Close()
+		_, err = Query(ctx, gone, WithHTTPProxy(proxy))
+		var pe *ProxyError
+		if !errors.As(err, &pe) || pe.StatusCode != http.StatusBadGateway {
+			t.Fatalf("Query returned %v, want a ProxyError with status 502", err)
+		}
+	})
+
+	t.Run("https", func(t *testing.T) {
+		proxy, asked := startProxy(t, 0)
+		proxy.Scheme = "https"
+		_, err := Query(ctx, nis, WithHTTPProxy(proxy))
+		if !errors.Is(err, ErrDial) || !strings.Contains(err.Error(), `unsupported scheme "https"`) || strings.Contains(err.Error(), "secret") {
+			t.Fatalf("Query returned %v, want an unsupported scheme error without the password", err)
+		}
+		if a := asked.Load().([]string); len(a) != 0 {
+			t.Errorf("the credentials were sent in the clear for %q", a)
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package apcupsc
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// RuntimeLow is the lowest TimeLeft observed for a UPS by a
+// RuntimeTracker.
+type RuntimeLow struct {
+	// TimeLeft is the lowest runtime observed.
+	TimeLeft time.Duration
+	// At is when it was observed.
+	At time.Time
+	// LoadPct is the load at that time.
+	LoadPct float64
+}
+
+// RuntimeTracker records the lowest runtime observed for each of a
+// set of UPSes while on line power, for reporting the worst case
+// runtime over a period. It is safe for concurrent use.
+type RuntimeTracker struct {
+	maxAge time.Duration
+
+	mu   sync.Mutex
+	lows map[string]RuntimeLow
+}
+
+// NewRuntimeTracker returns an empty RuntimeTracker. Samples that are
+// not Fresh by maxAge, allowing DefaultClockSkew, as of the time
+// passed to Update are ignored.
+func NewRuntimeTracker(maxAge time.Duration) *RuntimeTracker {
+	return &RuntimeTracker{
+		maxAge: maxAge,
+		lows:   make(map[string]RuntimeLow),
+	}
+}
+
+// Update considers the TimeLeft of t, sampled at time at, for the UPS
+// identified by key (for example its Name or address). Samples taken
+// while on battery, which naturally see the runtime fall, samples
+// that are not Fresh, and samples without a TimeLeft are ignored. It
+// returns whether the sample set a new low for key.
+func (r *RuntimeTracker) Update(key string, t *Target, at time.Time) bool {
+	if t == nil || t.Offline || !t.Reported("TimeLeft") || !t.freshAt(r.maxAge, DefaultClockSkew, at) {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if low, ok := r.lows[key]; ok && low.TimeLeft <= t.TimeLeft {
+		return false
+	}
+	r.lows[key] = RuntimeLow{TimeLeft: t.TimeLeft, At: at, LoadPct: t.LoadPct}
+	return true
+}
+
+// Snapshot returns a copy of the lowest runtimes recorded, keyed as
+// they were given to Update.
+func (r *RuntimeTracker) Snapshot() map[string]RuntimeLow {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	snap := make(map[string]RuntimeLow, len(r.lows))
+	for k, v := range r.lows {
+		snap[k] = v
+	}
+	return snap
+}
+
+// Reset forgets every recorded runtime, for example at the start of a
+// new reporting period.
+func (r *RuntimeTracker) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lows = make(map[string]RuntimeLow)
+}
+
+// Save writes the recorded runtimes to w as JSON, for restoring with
+// Load.
+func (r *RuntimeTracker) Save(w io.Writer) error {
+	return json.NewEncoder(w).Encode(r.Snapshot())
+}
+
+// Load reads runtimes written by Save from rd, merging them with
+// those already recorded so that the lower of each is kept.
+func (r *RuntimeTracker) Load(rd io.Reader) error {
+	var lows map[string]RuntimeLow
+	if err := json.NewDecoder(rd).Decode(&lows); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for k, v := range lows {
+		if low, ok := r.lows[k]; !ok || v.TimeLeft < low.TimeLeft {
+			r.lows[k] = v
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package apcupsc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRuntimeTracker(t *testing.T) {
+	online := parseDump(t, testDump)
+	at := online.SampledAt
+	low := parseDump(t, withLine(withLine(testDump, "TIMELEFT", "61.5 Minutes"), "LOADPCT", "22.0 Percent"))
+	r := NewRuntimeTracker(5 * time.Minute)
+	if !r.Update("ups", online, at) {
+		t.Fatal("Update ignored the first sample")
+	}
+	if !r.Update("ups", low, at.Add(time.Second)) {
+		t.Error("Update ignored a lower runtime")
+	}
+	if r.Update("ups", online, at.Add(2*time.Second)) {
+		t.Error("Update recorded a higher runtime as a low")
+	}
+	for name, dump := range map[string]string{
+		"on battery":   withLine(withLine(testDump, "STATUS", "ONBATT"), "TIMELEFT", "5.0 Minutes"),
+		"stale":        withLine(withLine(testDump, "DATE", "2024-10-19 10:00:00 -0700"), "TIMELEFT", "5.0 Minutes"),
+		"COMMLOST":     withLine(withLine(testDump, "STATUS", "COMMLOST"), "TIMELEFT", "5.0 Minutes"),
+		"no TIMELEFT":  withoutLine(testDump, "TIMELEFT"),
+		"TIMELEFT N/A": withLine(testDump, "TIMELEFT", "N/A"),
+	} {
+		if r.Update("ups", parseDump(t, dump), at.Add(3*time.Second)) {
+			t.Errorf("Update recorded a sample %s", name)
+		}
+	}
+	if !r.Update("other", online, at) {
+		t.Error("Update ignored the first sample of another UPS")
+	}
+	want := map[string]RuntimeLow{
+		"ups":   {TimeLeft: 61*time.Minute + 30*time.Second, At: at.Add(time.Second), LoadPct: 22},
+		"other": {TimeLeft: online.TimeLeft, At: at, LoadPct: 5},
+	}
+	checkLows(t, "Snapshot", r.Snapshot(), want)
+
+	r.Reset()
+	if snap := r.Snapshot(); len(snap) != 0 {
+		t.Errorf("Snapshot after Reset = %v, want none", snap)
+	}
+	if !r.Update("ups", online, at) {
+		t.Error("Update after Reset ignored a runtime higher than the old low")
+	}
+}
+
+func TestRuntimeTrackerPersist(t *testing.T) {
+	at := time.Date(2024, 10, 19, 11, 46, 30, 0, time.FixedZone("", -7*3600))
+	r := NewRuntimeTracker(5 * time.Minute)
+	saved := map[string]RuntimeLow{
+		"a": {TimeLeft: 61 * time.Minute, At: at, LoadPct: 22},
+		"b": {TimeLeft: 90 * time.Minute, At: at.Add(time.Hour), LoadPct: 8.5},
+	}
+	r.lows = saved
+	var buf bytes.Buffer
+	if err := r.Save(&buf); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	restored := NewRuntimeTracker(5 * time.Minute)
+	if err := restored.Load(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	checkLows(t, "Load", restored.Snapshot(), saved)
+
+	// Loading merges with what has been seen since, keeping the
+	// lower of each.
+	merged := NewRuntimeTracker(5 * time.Minute)
+	merged.lows = map[string]RuntimeLow{
+		"a": {TimeLeft: 70 * time.Minute, At: at.Add(2 * time.Hour), LoadPct: 10},
+		"b": {TimeLeft: 80 * time.Minute, At: at.Add(2 * time.Hour), LoadPct: 12},
+		"c": {TimeLeft: 30 * time.Minute, At: at.Add(2 * time.Hour), LoadPct: 40},
+	}
+	if err := merged.Load(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	checkLows(t, "merged Load", merged.Snapshot(), map[string]RuntimeLow{
+		"a": saved["a"],
+		"b": {TimeLeft: 80 * time.Minute, At: at.Add(2 * time.Hour), LoadPct: 12},
+		"c": {TimeLeft: 30 * time.Minute, At: at.Add(2 * time.Hour), LoadPct: 40},
+	})
+
+	if err := restored.Load(strings.NewReader(`{"a":`)); err == nil {
+		t.Error("Load of truncated JSON succeeded")
+	}
+	checkLows(t, "failed Load", restored.Snapshot(), saved)
+}
+
+// checkLows compares the runtimes a RuntimeTracker recorded with want.
+func checkLows(tb testing.TB, what string, got, want map[string]RuntimeLow) {
+	tb.Helper()
+	if len(got) != len(want) {
+		tb.Errorf("%s = %v, want %v", what, got, want)
+		return
+	}
+	for k, w := range want {
+		if g, ok := got[k]; !ok || g.TimeLeft != w.TimeLeft || !g.At.Equal(w.At) || g.LoadPct != w.LoadPct {
+			tb.Errorf("%s[%q] = %+v, want %+v", what, k, g, w)
+		}
+	}
+}
+
+func TestRuntimeTrackerFresh(t *testing.T) {
+	sample := parseDump(t, testDump)
+	r := NewRuntimeTracker(5 * time.Minute)
+	if r.Update("ups", sample, sample.SampledAt.Add(time.Hour)) {
+		t.Error("Update recorded a sample an hour old when given")
+	}
+	if !r.Update("ups", sample, sample.SampledAt.Add(time.Minute)) {
+		t.Error("Update ignored a sample a minute old when given")
+	}
+	lost := parseDump(t, withLine(withLine(testDump, "STATUS", "COMMLOST"), "TIMELEFT", "10.0 Minutes"))
+	if r.Update("ups", lost, lost.SampledAt) {
+		t.Error("Update recorded a runtime from a daemon that lost its UPS")
+	}
+}
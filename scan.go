@@ -0,0 +1,331 @@
+package apcupsc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ScanWorkers is the default number of concurrent connection
+// attempts made while scanning. It can be overridden with
+// WithScanWorkers.
+var ScanWorkers = 256
+
+// ErrNetwork indicates that a network to scan was not a supported
+// CIDR.
+var ErrNetwork = errors.New("unsupported network")
+
+// ScanResult identifies an apcupsd service found by scanning.
+type ScanResult struct {
+	// Addr is the host:port address of the service.
+	Addr string
+	// Network is the scanned network the address was found in.
+	Network string
+	// Err, for results rejected by ScanVerified, explains why the
+	// service at Addr was not recognized as apcupsd, for example
+	// a reset connection, a malformed response or
+	// context.DeadlineExceeded when no response arrived in time.
+	Err error
+}
+
+// Scan scans a network for apcupsd services. The network string is
+// provided in the format expected by net.ParseCIDR(). Scan returns a
+// slice of full port addresses found. This function currently only
+// support IPv4 networks.
+func Scan(network string, timeout time.Duration) []string {
+	ans, _ := ScanNetwork(context.Background(), network, WithDialTimeout(timeout))
+	return ans
+}
+
+// ScanNetwork is a variant of Scan that accepts a context and
+// Options. The port probed and the per-host connection timeout are
+// set with WithPort and WithDialTimeout. An invalid network is
+// reported as an error wrapping ErrNetwork.
+func ScanNetwork(ctx context.Context, network string, opts ...Option) ([]string, error) {
+	results, err := ScanNetworks(ctx, []string{network}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	var ans []string
+	for _, r := range results {
+		ans = append(ans, r.Addr)
+	}
+	return ans, nil
+}
+
+// scanRange returns the first and last IPv4 addresses to probe in
+// network. The network and broadcast addresses are skipped, except
+// for /31 networks where both addresses are hosts (RFC 3021) and /32
+// networks which name a single host.
+func scanRange(network string) (first, last uint32, err error) {
+	_, nInfo, err := net.ParseCIDR(network)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w %q: %v", ErrNetwork, network, err)
+	}
+	if len(nInfo.Mask) != 4 {
+		return 0, 0, fmt.Errorf("%w %q: not IPv4", ErrNetwork, network)
+	}
+	mask := binary.BigEndian.Uint32(nInfo.Mask)
+	first = binary.BigEndian.Uint32(nInfo.IP) & mask
+	last = first | ^mask
+	if ones, _ := nInfo.Mask.Size(); ones >= 31 {
+		return first, last, nil
+	}
+	return first + 1, last - 1, nil
+}
+
+// scanSpan is a range of IPv4 addresses to probe, and the network
+// they are attributed to.
+type scanSpan struct {
+	network     string
+	first, last uint32
+}
+
+// disjointSpans returns spans with the addresses of each span that
+// an earlier one also covers removed, so that overlapping networks
+// are only probed once, by the first network listing them. Unlike
+// remembering every address probed, this takes memory in proportion
+// to the number of spans, however many addresses they cover.
+func disjointSpans(spans []scanSpan) []scanSpan {
+	var out, covered []scanSpan
+	for _, s := range spans {
+		// covered is ordered and holds no overlapping or adjacent
+		// spans, so the gaps in it are what s adds.
+		next := uint64(s.first)
+		for _, c := range covered {
+			if c.first > s.last {
+				break
+			}
+			if uint64(c.first) > next {
+				out = append(out, scanSpan{s.network, uint32(next), c.first - 1})
+			}
+			next = max(next, uint64(c.last)+1)
+		}
+		if next <= uint64(s.last) {
+			out = append(out, scanSpan{s.network, uint32(next), s.last})
+		}
+
+		covered = append(covered, s)
+		sort.Slice(covered, func(a, b int) bool { return covered[a].first < covered[b].first })
+		merged := []scanSpan{covered[0]}
+		for _, c := range covered[1:] {
+			if m := &merged[len(merged)-1]; uint64(c.first) <= uint64(m.last)+1 {
+				m.last = max(m.last, c.last)
+			} else {
+				merged = append(merged, c)
+			}
+		}
+		covered = merged
+	}
+	return out
+}
+
+// ScanNetworks scans several networks for apcupsd services, sharing
+// one pool of concurrent connection attempts (see WithScanWorkers)
+// and, optionally, one rate limit (see WithScanRate). Addresses in
+// overlapping networks are only probed once, and are attributed to
+// the first network listing them. Every network is validated before
+// scanning begins, and an invalid one is reported as an error
+// wrapping ErrNetwork. The results are ordered by address.
+func ScanNetworks(ctx context.Context, networks []string, opts ...Option) ([]ScanResult, error) {
+	ch, err := newConfig(opts).scan(ctx, networks)
+	if err != nil {
+		return nil, err
+	}
+	var ans []ScanResult
+	for r := range ch {
+		ans = append(ans, r)
+	}
+	sortScanResults(ans)
+	return ans, nil
+}
+
+// ScanPorts is a variant of ScanNetworks that probes each of the
+// ports of every address in network, for hosts that run one apcupsd
+// daemon per attached UPS on consecutive ports. Each daemon found is
+// a separate result. The results are ordered by address and then
+// port.
+func ScanPorts(ctx context.Context, network string, ports []int, opts ...Option) ([]ScanResult, error) {
+	ch, err := newConfig(opts).scan(ctx, []string{network}, ports...)
+	if err != nil {
+		return nil, err
+	}
+	var ans []ScanResult
+	for r := range ch {
+		ans = append(ans, r)
+	}
+	sortScanResults(ans)
+	return ans, nil
+}
+
+// ScanVerified is a variant of ScanNetworks that confirms each open
+// port answers a status query before reporting it as found. Hosts
+// that accept a connection but fail the query, such as misconfigured
+// exporters or intercepting firewalls, are returned as rejected, with
+// the reason in their Err field. The response to the query must
+// arrive within the dial timeout (see WithDialTimeout) of
+// connecting. Both slices are ordered by address.
+func ScanVerified(ctx context.Context, networks []string, opts ...Option) (found, rejected []ScanResult, err error) {
+	cfg := newConfig(opts)
+	cfg.verify = true
+	ch, err := cfg.scan(ctx, networks)
+	if err != nil {
+		return nil, nil, err
+	}
+	for r := range ch {
+		if r.Err != nil {
+			rejected = append(rejected, r)
+		} else {
+			found = append(found, r)
+		}
+	}
+	sortScanResults(found)
+	sortScanResults(rejected)
+	return found, rejected, nil
+}
+
+// sortScanResults orders results by address, and then port.
+func sortScanResults(results []ScanResult) {
+	sort.Slice(results, func(a, b int) bool {
+		x := netip.MustParseAddrPort(results[a].Addr)
+		y := netip.MustParseAddrPort(results[b].Addr)
+		return x.Compare(y) < 0
+	})
+}
+
+// ScanStream scans a network for apcupsd services, sending the
+// address of each one found on the returned channel as soon as it is
+// found. The channel is closed when the scan completes or ctx is
+// cancelled. The scan waits for the channel to be read, so a slow
+// reader delays rather than loses results. The opts are as for
+// ScanNetwork.
+func ScanStream(ctx context.Context, network string, timeout time.Duration, opts ...Option) (<-chan string, error) {
+	cfg := newConfig(append(opts, WithDialTimeout(timeout)))
+	ch, err := cfg.scan(ctx, []string{network})
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		for r := range ch {
+			select {
+			case out <- r.Addr:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// scan validates the networks and then scans each of the ports, by
+// default the configured port, of their addresses, sending results
+// on the returned channel as they are found. The channel is closed
+// once the scan completes or ctx is cancelled.
+func (cfg *config) scan(ctx context.Context, networks []string, ports ...int) (<-chan ScanResult, error) {
+	if len(ports) == 0 {
+		ports = []int{cfg.port}
+	}
+	var spans []scanSpan
+	for _, network := range networks {
+		first, last, err := scanRange(network)
+		if err != nil {
+			return nil, err
+		}
+		spans = append(spans, scanSpan{network, first, last})
+	}
+	spans = disjointSpans(spans)
+
+	type job struct {
+		ip      uint32
+		port    int
+		network string
+	}
+	jobs := make(chan job)
+	go func() {
+		defer close(jobs)
+		// A single pacing ticker is shared by all of the workers.
+		var pace <-chan time.Time
+		if cfg.rate > 0 && time.Second/time.Duration(cfg.rate) > 0 {
+			ticker := time.NewTicker(time.Second / time.Duration(cfg.rate))
+			defer ticker.Stop()
+			pace = ticker.C
+		}
+		for _, s := range spans {
+			for n := uint64(s.first); n <= uint64(s.last); n++ {
+				ip := uint32(n)
+				for _, port := range ports {
+					if pace != nil {
+						select {
+						case <-pace:
+						case <-ctx.Done():
+							return
+						}
+					}
+					select {
+					case jobs <- job{ip, port, s.network}:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	// Each worker closes its connection before publishing a result,
+	// and the buffer lets workers keep probing while the reader
+	// catches up.
+	workers := max(cfg.workers, 1)
+	out := make(chan ScanResult, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				addr := scanAddr(j.ip, j.port)
+				c, err := cfg.dial(ctx, addr, cfg.dialTimeout)
+				if err != nil {
+					continue
+				}
+				r := ScanResult{Addr: addr, Network: j.network}
+				if cfg.verify {
+					r.Err = cfg.verifyConn(ctx, c)
+				}
+				c.Close()
+				select {
+				case out <- r:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out, nil
+}
+
+// verifyConn confirms that c is connected to an apcupsd service by
+// querying its status within the dial timeout.
+func (cfg *config) verifyConn(ctx context.Context, c net.Conn) error {
+	ctx, cancel := context.WithTimeout(ctx, cfg.dialTimeout)
+	defer cancel()
+	_, err := cfg.exchange(ctx, cfg.newConn(c))
+	return err
+}
+
+// scanAddr formats an IPv4 address and port as a host:port address.
+func scanAddr(n uint32, port int) string {
+	ip := make([]byte, 4)
+	binary.BigEndian.PutUint32(ip, n)
+	return Endpoint(net.IP(ip).String(), port)
+}
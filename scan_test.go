@@ -0,0 +1,405 @@
+package apcupsc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// ip4 returns the IPv4 address a.b.c.d as a uint32.
+func ip4(a, b, c, d byte) uint32 {
+	return uint32(a)<<24 | uint32(b)<<16 | uint32(c)<<8 | uint32(d)
+}
+
+func TestScanRange(t *testing.T) {
+	tests := []struct {
+		network     string
+		first, last uint32
+		err         bool
+	}{
+		{network: "0.0.0.0/0", first: 1, last: math.MaxUint32 - 1},
+		{network: "10.0.0.0/8", first: ip4(10, 0, 0, 1), last: ip4(10, 255, 255, 254)},
+		{network: "192.168.1.0/24", first: ip4(192, 168, 1, 1), last: ip4(192, 168, 1, 254)},
+		{network: "192.168.1.77/24", first: ip4(192, 168, 1, 1), last: ip4(192, 168, 1, 254)},
+		{network: "192.168.1.4/30", first: ip4(192, 168, 1, 5), last: ip4(192, 168, 1, 6)},
+		{network: "192.168.1.7/30", first: ip4(192, 168, 1, 5), last: ip4(192, 168, 1, 6)},
+		{network: "192.168.1.4/31", first: ip4(192, 168, 1, 4), last: ip4(192, 168, 1, 5)},
+		{network: "192.168.1.5/31", first: ip4(192, 168, 1, 4), last: ip4(192, 168, 1, 5)},
+		{network: "192.168.1.9/32", first: ip4(192, 168, 1, 9), last: ip4(192, 168, 1, 9)},
+		{network: "255.255.255.255/32", first: math.MaxUint32, last: math.MaxUint32},
+		{network: "fd00::/120", err: true},
+		{network: "::1/128", err: true},
+		{network: "10.0.0.0/33", err: true},
+		{network: "10.0.0.0", err: true},
+		{network: "10.0.0/24", err: true},
+		{network: "", err: true},
+	}
+	for _, tc := range tests {
+		first, last, err := scanRange(tc.network)
+		if tc.err {
+			if !errors.Is(err, ErrNetwork) {
+				t.Errorf("scanRange(%q) = %d, %d, %v, want an ErrNetwork", tc.network, first, last, err)
+			}
+			continue
+		}
+		if err != nil || first != tc.first || last != tc.last {
+			t.Errorf("scanRange(%q) = %d, %d, %v, want %d, %d", tc.network, first, last, err, tc.first, tc.last)
+		}
+	}
+}
+
+func TestDisjointSpans(t *testing.T) {
+	type span = scanSpan
+	for _, tc := range []struct {
+		name string
+		in   []span
+		want []span
+	}{
+		{"one", []span{{"a", 10, 20}}, []span{{"a", 10, 20}}},
+		{"apart", []span{{"a", 10, 20}, {"b", 30, 40}}, []span{{"a", 10, 20}, {"b", 30, 40}}},
+		{"duplicate", []span{{"a", 10, 20}, {"b", 10, 20}}, []span{{"a", 10, 20}}},
+		{"inside", []span{{"a", 10, 20}, {"b", 12, 15}}, []span{{"a", 10, 20}}},
+		{"around", []span{{"a", 12, 15}, {"b", 10, 20}}, []span{{"a", 12, 15}, {"b", 10, 11}, {"b", 16, 20}}},
+		{"overlap", []span{{"a", 10, 20}, {"b", 15, 25}}, []span{{"a", 10, 20}, {"b", 21, 25}}},
+		{"gaps", []span{{"a", 10, 12}, {"b", 16, 18}, {"c", 0, 30}}, []span{{"a", 10, 12}, {"b", 16, 18}, {"c", 0, 9}, {"c", 13, 15}, {"c", 19, 30}}},
+		{"adjacent", []span{{"a", 10, 12}, {"b", 13, 15}, {"c", 10, 15}}, []span{{"a", 10, 12}, {"b", 13, 15}}},
+		{"everything", []span{{"a", 0, math.MaxUint32}, {"b", 0, math.MaxUint32}, {"c", 5, 5}}, []span{{"a", 0, math.MaxUint32}}},
+		{"top", []span{{"a", math.MaxUint32 - 1, math.MaxUint32}, {"b", math.MaxUint32 - 3, math.MaxUint32}}, []span{{"a", math.MaxUint32 - 1, math.MaxUint32}, {"b", math.MaxUint32 - 3, math.MaxUint32 - 2}}},
+	} {
+		if got := disjointSpans(tc.in); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: disjointSpans(%v) = %v, want %v", tc.name, tc.in, got, tc.want)
+		}
+	}
+}
+
+// TestDisjointSpansCover checks disjointSpans against remembering
+// every address, for random sets of small overlapping spans.
+func TestDisjointSpansCover(t *testing.T) {
+	r := rand.New(rand.NewPCG(5, 6))
+	for range 1000 {
+		var in []scanSpan
+		for i := range 1 + r.IntN(6) {
+			first := r.Uint32N(64)
+			in = append(in, scanSpan{string(rune('a' + i)), first, first + r.Uint32N(16)})
+		}
+		want := make(map[uint32]string)
+		for _, s := range in {
+			for ip := s.first; ip <= s.last; ip++ {
+				if _, ok := want[ip]; !ok {
+					want[ip] = s.network
+				}
+			}
+		}
+		got := make(map[uint32]string)
+		for _, s := range disjointSpans(in) {
+			for ip := s.first; ip <= s.last; ip++ {
+				if n, ok := got[ip]; ok {
+					t.Fatalf("disjointSpans(%v) covers %d twice, in %s and %s", in, ip, n, s.network)
+				}
+				got[ip] = s.network
+			}
+		}
+		if len(got) != len(want) {
+			t.Fatalf("disjointSpans(%v) covers %d addresses, want %d", in, len(got), len(want))
+		}
+		for ip, n := range want {
+			if got[ip] != n {
+				t.Fatalf("disjointSpans(%v) attributes %d to %q, want %q", in, ip, got[ip], n)
+			}
+		}
+	}
+}
+
+// fakeDialer records the addresses dialed, and when, connecting to
+// those in open and refusing the others.
+type fakeDialer struct {
+	open map[string]bool
+
+	mu     sync.Mutex
+	dialed []string
+	at     []time.Time
+}
+
+func (d *fakeDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.mu.Lock()
+	d.dialed = append(d.dialed, addr)
+	d.at = append(d.at, time.Now())
+	d.mu.Unlock()
+	if !d.open[addr] {
+		return nil, errors.New("connection refused")
+	}
+	c, s := net.Pipe()
+	s.Close()
+	return c, nil
+}
+
+func TestScanNetworksOverlap(t *testing.T) {
+	d := &fakeDialer{open: map[string]bool{
+		"10.0.0.2:3551":  true,
+		"10.0.0.6:3551":  true,
+		"10.0.0.13:3551": true,
+	}}
+	got, err := ScanNetworks(context.Background(), []string{"10.0.0.4/30", "10.0.0.0/28", "10.0.0.0/29"}, WithDialer(d), WithScanWorkers(4))
+	if err != nil {
+		t.Fatalf("ScanNetworks: %v", err)
+	}
+	want := []ScanResult{
+		{Addr: "10.0.0.2:3551", Network: "10.0.0.0/28"},
+		{Addr: "10.0.0.6:3551", Network: "10.0.0.4/30"},
+		{Addr: "10.0.0.13:3551", Network: "10.0.0.0/28"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("ScanNetworks = %v, want %v", got, want)
+	}
+	// The hosts of 10.0.0.0/28 are 10.0.0.1 to 10.0.0.14, but the
+	// network and broadcast addresses of 10.0.0.4/30 are hosts of
+	// the larger network.
+	if len(d.dialed) != 14 {
+		t.Errorf("dialed %d addresses, want each of the 14 hosts once: %v", len(d.dialed), d.dialed)
+	}
+	if slices.Sort(d.dialed); len(slices.Compact(d.dialed)) != len(d.dialed) {
+		t.Errorf("dialed some addresses more than once: %v", d.dialed)
+	}
+}
+
+func TestScanNetworksInvalid(t *testing.T) {
+	for _, bad := range []string{"bogus", "10.0.0.0/33", "fd00::/120", ""} {
+		d := &fakeDialer{}
+		got, err := ScanNetworks(context.Background(), []string{"10.0.0.0/30", bad, "10.0.1.0/30"}, WithDialer(d))
+		if !errors.Is(err, ErrNetwork) || got != nil {
+			t.Errorf("ScanNetworks with %q = %v, %v, want an ErrNetwork", bad, got, err)
+		}
+		if len(d.dialed) != 0 {
+			t.Errorf("ScanNetworks with %q dialed %v before validating every network", bad, d.dialed)
+		}
+	}
+}
+
+func TestScanRate(t *testing.T) {
+	const (
+		rate     = 100
+		interval = time.Second / rate
+		// slack allows for the scheduling of the workers between
+		// taking a job and dialing.
+		slack = 2 * time.Millisecond
+	)
+	d := &fakeDialer{}
+	start := time.Now()
+	if _, err := ScanNetworks(context.Background(), []string{"10.0.0.0/28"}, WithDialer(d), WithScanWorkers(4), WithScanRate(rate)); err != nil {
+		t.Fatalf("ScanNetworks: %v", err)
+	}
+	if len(d.at) != 14 {
+		t.Fatalf("dialed %d addresses, want 14", len(d.at))
+	}
+	slices.SortFunc(d.at, time.Time.Compare)
+	// The shared ticker may hold one tick back, so two attempts can
+	// come close together, but never three.
+	for i := 2; i < len(d.at); i++ {
+		if gap := d.at[i].Sub(d.at[i-2]); gap < interval-slack {
+			t.Errorf("attempts %d to %d made within %v, want at least %v", i-2, i, gap, interval)
+		}
+	}
+	if took, want := d.at[len(d.at)-1].Sub(start), 13*interval; took < want-slack {
+		t.Errorf("14 attempts at %d a second took %v, want at least %v", rate, took, want)
+	}
+
+	d = &fakeDialer{}
+	start = time.Now()
+	if _, err := ScanNetworks(context.Background(), []string{"10.0.0.0/28"}, WithDialer(d), WithScanWorkers(4), WithScanRate(0)); err != nil {
+		t.Fatalf("ScanNetworks: %v", err)
+	}
+	if took := time.Since(start); took >= 13*interval {
+		t.Errorf("unlimited scan of 14 addresses took %v, as if paced", took)
+	}
+}
+
+// redirectDialer connects to the addresses in to in place of those
+// dialed, refusing the others, so that a scan of a whole network can
+// reach services listening on loopback.
+type redirectDialer map[string]string
+
+func (d redirectDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	to, ok := d[addr]
+	if !ok {
+		return nil, errors.New("connection refused")
+	}
+	var nd net.Dialer
+	return nd.DialContext(ctx, network, to)
+}
+
+func TestScanVerified(t *testing.T) {
+	nis, _ := startNIS(t, answer(testDump))
+	garbage, _ := listen(t, func(c net.Conn) {
+		c.Write([]byte("HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\n\r\n"))
+	})
+	reset, _ := listen(t, func(c net.Conn) {})
+	silent, _ := listen(t, func(c net.Conn) {
+		io.Copy(io.Discard, c)
+	})
+	d := redirectDialer{
+		"10.0.0.1:3551": nis,
+		"10.0.0.2:3551": garbage,
+		"10.0.0.3:3551": reset,
+		"10.0.0.4:3551": silent,
+	}
+	found, rejected, err := ScanVerified(context.Background(), []string{"10.0.0.0/29"},
+		WithDialer(d), WithDialTimeout(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("ScanVerified: %v", err)
+	}
+	if want := []ScanResult{{Addr: "10.0.0.1:3551", Network: "10.0.0.0/29"}}; !slices.Equal(found, want) {
+		t.Errorf("found %v, want %v", found, want)
+	}
+	var addrs []string
+	for _, r := range rejected {
+		addrs = append(addrs, r.Addr)
+		if r.Err == nil {
+			t.Errorf("%s rejected without a reason", r.Addr)
+		}
+		if r.Network != "10.0.0.0/29" {
+			t.Errorf("%s rejected from network %q", r.Addr, r.Network)
+		}
+	}
+	if want := []string{"10.0.0.2:3551", "10.0.0.3:3551", "10.0.0.4:3551"}; !slices.Equal(addrs, want) {
+		t.Fatalf("rejected %v, want %v", rejected, want)
+	}
+	if err := rejected[2].Err; !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("silent %s rejected with %v, want a timeout", rejected[2].Addr, err)
+	}
+
+	// The same scan, unverified, reports every open port.
+	plain, err := ScanNetworks(context.Background(), []string{"10.0.0.0/29"}, WithDialer(d))
+	if err != nil {
+		t.Fatalf("ScanNetworks: %v", err)
+	}
+	if len(plain) != 4 {
+		t.Errorf("ScanNetworks = %v, want the 4 open ports", plain)
+	}
+	for _, r := range plain {
+		if r.Err != nil {
+			t.Errorf("unverified %s has Err %v", r.Addr, r.Err)
+		}
+	}
+}
+
+// countingDialer dials with base, keeping count of the connections it
+// has open and of the most it has had open at once.
+type countingDialer struct {
+	base       Dialer
+	open, peak atomic.Int64
+}
+
+func (d *countingDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	c, err := d.base.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	n := d.open.Add(1)
+	for p := d.peak.Load(); n > p && !d.peak.CompareAndSwap(p, n); p = d.peak.Load() {
+	}
+	return &countedConn{Conn: c, d: d}, nil
+}
+
+// countedConn is a connection made by a countingDialer.
+type countedConn struct {
+	net.Conn
+	d    *countingDialer
+	once sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() { c.d.open.Add(-1) })
+	return c.Conn.Close()
+}
+
+func TestScanStress(t *testing.T) {
+	const (
+		hosts   = 3000
+		workers = 64
+	)
+	if testing.Short() {
+		t.Skip("opens thousands of loopback listeners")
+	}
+	// Every other address of 10.0.0.0/19 has a listener, up to
+	// hosts of them. The connections need not be accepted, as the
+	// scan only dials them.
+	redirect := redirectDialer{}
+	var want []string
+	for n := ip4(10, 0, 0, 1); len(want) < hosts; n += 2 {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Skipf("opened only %d of %d listeners: %v", len(want), hosts, err)
+		}
+		t.Cleanup(func() { l.Close() })
+		redirect[scanAddr(n, 3551)] = l.Addr().String()
+		want = append(want, scanAddr(n, 3551))
+	}
+	d := &countingDialer{base: redirect}
+	ch, err := ScanStream(context.Background(), "10.0.0.0/19", time.Second, WithDialer(d), WithScanWorkers(workers))
+	if err != nil {
+		t.Fatalf("ScanStream: %v", err)
+	}
+	seen := make(map[string]int)
+	for addr := range ch {
+		if seen[addr]++; len(seen)%500 == 0 {
+			// While the reader stalls the probes wait to
+			// publish their results, but must not hold their
+			// connections open meanwhile.
+			time.Sleep(10 * time.Millisecond)
+			waitFor(t, "probes to close their connections", func() bool { return d.open.Load() == 0 })
+		}
+	}
+	for _, addr := range want {
+		if seen[addr] != 1 {
+			t.Errorf("%s reported %d times, want once", addr, seen[addr])
+		}
+	}
+	if len(seen) != hosts {
+		t.Errorf("reported %d addresses, want %d", len(seen), hosts)
+	}
+	if open := d.open.Load(); open != 0 {
+		t.Errorf("%d connections left open after the scan", open)
+	}
+	if peak := d.peak.Load(); peak > workers {
+		t.Errorf("%d connections open at once, want at most one per %d workers", peak, workers)
+	}
+}
+
+// BenchmarkScanDescriptors scans a /22 of which every address answers,
+// read by a consumer that stalls now and then, and reports the most
+// connections the scan held open at once and while the consumer was
+// stalled.
+func BenchmarkScanDescriptors(b *testing.B) {
+	const workers = 64
+	open := make(map[string]bool)
+	for n := ip4(10, 0, 0, 1); n < ip4(10, 0, 4, 0); n++ {
+		open[scanAddr(n, 3551)] = true
+	}
+	var peak, stalled int64
+	for i := 0; i < b.N; i++ {
+		d := &countingDialer{base: &fakeDialer{open: open}}
+		ch, err := ScanStream(context.Background(), "10.0.0.0/22", time.Second, WithDialer(d), WithScanWorkers(workers))
+		if err != nil {
+			b.Fatal(err)
+		}
+		n := 0
+		for range ch {
+			if n++; n%256 == 0 {
+				time.Sleep(time.Millisecond)
+				stalled = max(stalled, d.open.Load())
+			}
+		}
+		peak = max(peak, d.peak.Load())
+	}
+	b.ReportMetric(float64(peak), "peak-conns")
+	b.ReportMetric(float64(stalled), "stalled-conns")
+}
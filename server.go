@@ -0,0 +1,72 @@
+package apcupsc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"strings"
+	"time"
+)
+
+// writeFrame writes a single frame of the apcupsd line encoding.
+func writeFrame(w *bufio.Writer, s string) error {
+	var hdr [2]byte
+	binary.BigEndian.PutUint16(hdr[:], uint16(len(s)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.WriteString(s)
+	return err
+}
+
+// Serve accepts connections on l and answers them as an apcupsd
+// network information server would. Each "status" command is
+// answered by rendering the Target returned by source, which might
+// be a Cache or one of several upstream daemons, as apcupsd status
+// lines. An "events" command is answered with an empty event log,
+// and other commands are rejected. If source fails, or returns no
+// Target, the status response is empty. Serve only returns when l
+// fails, typically because it has been closed, returning the Accept
+// error.
+func Serve(l net.Listener, source func() (*Target, error)) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go serveConn(c, source)
+	}
+}
+
+// serveConn answers the commands received over a single connection.
+func serveConn(c net.Conn, source func() (*Target, error)) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	w := bufio.NewWriter(c)
+	for {
+		cmd, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		switch strings.TrimSpace(cmd) {
+		case "status":
+			t, err := source()
+			if err != nil || t == nil {
+				break
+			}
+			for _, line := range t.encode(TimeLocation, time.Now()) {
+				if writeFrame(w, line+"\n") != nil {
+					return
+				}
+			}
+		case "events":
+		default:
+			if writeFrame(w, "Invalid command\n") != nil {
+				return
+			}
+		}
+		if writeFrame(w, "") != nil || w.Flush() != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package apcupsc
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+// startServe runs Serve on a loopback listener, closed when the test
+// ends, returning its address.
+func startServe(tb testing.TB, source func() (*Target, error)) string {
+	tb.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("listen: %v", err)
+	}
+	done := make(chan error)
+	go func() { done <- Serve(l, source) }()
+	tb.Cleanup(func() {
+		l.Close()
+		<-done
+	})
+	return l.Addr().String()
+}
+
+func TestServeRoundTrip(t *testing.T) {
+	want := parseDump(t, testDump, WithExtra())
+	down := errors.New("upstream down")
+	// answer is what the source returns: a Target, an error, or
+	// nothing at all.
+	var answer atomic.Int64
+	addr := startServe(t, func() (*Target, error) {
+		switch answer.Load() {
+		case 1:
+			return nil, down
+		case 2:
+			return nil, nil
+		}
+		return want, nil
+	})
+
+	c := NewClient(addr, WithExtra())
+	defer c.Close()
+	for i, a := range []int64{0, 1, 2, 0, 0} {
+		answer.Store(a)
+		got, err := c.Status(context.Background())
+		if a != 0 {
+			if !errors.Is(err, ErrIncomplete) || got != nil {
+				t.Errorf("status %d = %v, %v, want an empty response", i, got, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("status %d: %v", i, err)
+		}
+		for _, d := range diffFields(want, got) {
+			t.Errorf("status %d: %s", i, d)
+		}
+		if got.Extra["SERIALNO"] != "3B1234X12345" || got.Extra["MODEL"] != "Back-UPS XS 1500M" {
+			t.Errorf("status %d: Extra %v lacks the unparsed keys", i, got.Extra)
+		}
+	}
+
+	// The other commands are answered on the same connection.
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for _, tc := range []struct {
+		cmd  string
+		want []string
+	}{
+		{"events", nil},
+		{"bogus", []string{"Invalid command"}},
+		{"events", nil},
+	} {
+		if _, err := conn.Write(frameLines(tc.cmd + "\n")[:2+len(tc.cmd)+1]); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for {
+			line, err := readFrame(r)
+			if err != nil {
+				t.Fatalf("%s: %v", tc.cmd, err)
+			}
+			if line == "" {
+				break
+			}
+			got = append(got, line)
+		}
+		if len(got) != len(tc.want) || (len(got) > 0 && got[0] != tc.want[0]) {
+			t.Errorf("%s answered %q, want %q", tc.cmd, got, tc.want)
+		}
+	}
+}
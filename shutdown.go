@@ -0,0 +1,58 @@
+package apcupsc
+
+import "time"
+
+// DefaultMinBCharge and DefaultMinTimeLeft are the conservative
+// shutdown thresholds assumed when a Target does not report the
+// daemon's own MBATTCHG and MINTIMEL values.
+var (
+	DefaultMinBCharge  = 10.0
+	DefaultMinTimeLeft = 5 * time.Minute
+)
+
+// DefaultShutdownLead is a suitable lead for ShutdownImminent, the
+// time needed to act ahead of the daemon shutting down its host.
+const DefaultShutdownLead = 5 * time.Minute
+
+// ShutdownMargin estimates how much runtime remains before apcupsd
+// shuts down its host, that is before either BCharge falls to
+// MinBCharge or TimeLeft falls to MinTimeLeft. The charge threshold
+// is converted to a runtime by assuming the battery discharges
+// linearly over TimeLeft. The margin is never negative. The second
+// return value is false when either threshold was not reported and
+// the DefaultMinBCharge or DefaultMinTimeLeft fallback was used, or
+// when BCharge was not reported and the margin only allows for
+// MinTimeLeft. A threshold reported as 0, which disables it in
+// apcupsd, is honored.
+func (t *Target) ShutdownMargin() (time.Duration, bool) {
+	known := true
+	minCharge, minLeft := t.MinBCharge, t.MinTimeLeft
+	if !t.Reported("MinBCharge") {
+		minCharge, known = DefaultMinBCharge, false
+	}
+	if !t.Reported("MinTimeLeft") {
+		minLeft, known = DefaultMinTimeLeft, false
+	}
+	margin := t.TimeLeft - minLeft
+	switch {
+	case !t.Reported("BCharge"):
+		known = false
+	case t.BCharge > 0:
+		byCharge := time.Duration(float64(t.TimeLeft) * (t.BCharge - minCharge) / t.BCharge)
+		margin = min(margin, byCharge)
+	default:
+		margin = 0
+	}
+	return max(margin, 0), known
+}
+
+// ShutdownImminent reports whether the UPS is on battery and within
+// lead, such as DefaultShutdownLead, of the daemon shutting down its
+// host. See ShutdownMargin for how the thresholds are determined.
+func (t *Target) ShutdownImminent(lead time.Duration) bool {
+	if !t.Offline {
+		return false
+	}
+	margin, _ := t.ShutdownMargin()
+	return margin <= lead
+}
@@ -0,0 +1,75 @@
+package apcupsc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShutdownMargin(t *testing.T) {
+	onBattery := withLine(withLine(testDump, "STATUS", "ONBATT"), "TIMELEFT", "20.0 Minutes")
+	for _, tc := range []struct {
+		name           string
+		bcharge, minCh string
+		minLeft        string
+		want           time.Duration
+		known          bool
+	}{
+		// 20 minutes at 50% leaves 18 to the 5% MBATTCHG and 17 to
+		// the 3 minute MINTIMEL.
+		{"reported", "50.0 Percent", "5 Percent", "3 Minutes", 17 * time.Minute, true},
+		{"charge first", "50.0 Percent", "25 Percent", "3 Minutes", 10 * time.Minute, true},
+		// Thresholds of 0 disable the checks rather than being
+		// missing.
+		{"zero thresholds", "50.0 Percent", "0 Percent", "0 Minutes", 20 * time.Minute, true},
+		{"zero charge", "50.0 Percent", "0 Percent", "3 Minutes", 17 * time.Minute, true},
+		// Missing thresholds fall back to the 10% and 5 minute
+		// defaults.
+		{"missing", "50.0 Percent", "", "", 15 * time.Minute, false},
+		{"not available", "50.0 Percent", "N/A", "N/A", 15 * time.Minute, false},
+		{"missing time", "50.0 Percent", "5 Percent", "", 15 * time.Minute, false},
+		{"exhausted", "4.0 Percent", "5 Percent", "3 Minutes", 0, true},
+		{"empty", "0.0 Percent", "5 Percent", "3 Minutes", 0, true},
+		// Without BCHARGE only MINTIMEL applies.
+		{"no charge", "", "5 Percent", "3 Minutes", 17 * time.Minute, false},
+		{"charge not available", "N/A", "5 Percent", "3 Minutes", 17 * time.Minute, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dump := onBattery
+			for key, value := range map[string]string{"BCHARGE": tc.bcharge, "MBATTCHG": tc.minCh, "MINTIMEL": tc.minLeft} {
+				if value == "" {
+					dump = withoutLine(dump, key)
+				} else {
+					dump = withLine(dump, key, value)
+				}
+			}
+			got, known := parseDump(t, dump).ShutdownMargin()
+			if got.Round(time.Second) != tc.want || known != tc.known {
+				t.Errorf("ShutdownMargin() = %v, %v, want %v, %v", got, known, tc.want, tc.known)
+			}
+		})
+	}
+}
+
+func TestShutdownImminent(t *testing.T) {
+	onBattery := withLine(withLine(testDump, "STATUS", "ONBATT"), "BCHARGE", "90.0 Percent")
+	for _, tc := range []struct {
+		dump     string
+		timeLeft string
+		lead     time.Duration
+		want     bool
+	}{
+		{testDump, "6.0 Minutes", DefaultShutdownLead, false},
+		{onBattery, "6.0 Minutes", DefaultShutdownLead, true},
+		{onBattery, "6.0 Minutes", time.Minute, false},
+		{onBattery, "30.0 Minutes", DefaultShutdownLead, false},
+		// An unreported BCHARGE does not read as an empty battery.
+		{withoutLine(onBattery, "BCHARGE"), "103.2 Minutes", DefaultShutdownLead, false},
+		{withoutLine(onBattery, "BCHARGE"), "6.0 Minutes", DefaultShutdownLead, true},
+	} {
+		sample := parseDump(t, withLine(tc.dump, "TIMELEFT", tc.timeLeft))
+		if got := sample.ShutdownImminent(tc.lead); got != tc.want {
+			margin, _ := sample.ShutdownMargin()
+			t.Errorf("ShutdownImminent(%v) with STATUS %q and margin %v = %v, want %v", tc.lead, sample.Status, margin, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package apcupsc
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// smoothed is the running average of one UPS.
+type smoothed struct {
+	watts float64
+	at    time.Time
+}
+
+// PowerSmoother computes an exponential moving average of the Power
+// of a set of UPSes. It is safe for concurrent use.
+type PowerSmoother struct {
+	alpha    float64
+	interval time.Duration
+
+	mu   sync.Mutex
+	avgs map[string]smoothed
+}
+
+// NewPowerSmoother returns a PowerSmoother that weights each new
+// sample by alpha (0 < alpha <= 1) when samples are interval apart.
+// Samples further apart are weighted more heavily, as if the missed
+// intervening samples had carried the new value, so a gap in
+// sampling decays the old average rather than being ignored.
+func NewPowerSmoother(alpha float64, interval time.Duration) *PowerSmoother {
+	return &PowerSmoother{
+		alpha:    min(max(alpha, 0), 1),
+		interval: interval,
+		avgs:     make(map[string]smoothed),
+	}
+}
+
+// Update folds the Power of t, sampled at time at, into the average
+// for the UPS identified by key (for example its Name or address),
+// and returns the smoothed Watts. Samples older than the most recent
+// one for key, and samples that do not report Power, are ignored.
+func (s *PowerSmoother) Update(key string, t *Target, at time.Time) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, ok := s.avgs[key]
+	if !t.Reported("Power") {
+		return prev.watts
+	}
+	if !ok {
+		s.avgs[key] = smoothed{watts: float64(t.Power), at: at}
+		return float64(t.Power)
+	}
+	if !at.After(prev.at) {
+		return prev.watts
+	}
+	w := s.alpha
+	if s.interval > 0 {
+		steps := float64(at.Sub(prev.at)) / float64(s.interval)
+		w = 1 - math.Pow(1-s.alpha, steps)
+	}
+	avg := prev.watts + w*(float64(t.Power)-prev.watts)
+	s.avgs[key] = smoothed{watts: avg, at: at}
+	return avg
+}
+
+// Watts returns the current smoothed Watts for key, and whether any
+// samples have been seen for it.
+func (s *PowerSmoother) Watts(key string) (float64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	avg, ok := s.avgs[key]
+	return avg.watts, ok
+}
@@ -0,0 +1,56 @@
+package apcupsc
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPowerSmoother(t *testing.T) {
+	at := time.Date(2024, 10, 19, 11, 0, 0, 0, time.UTC)
+	watts := func(w int) *Target { return &Target{Power: w} }
+	s := NewPowerSmoother(0.5, time.Second)
+	steps := []struct {
+		name string
+		t    *Target
+		at   time.Duration
+		want float64
+	}{
+		{"first", watts(100), 0, 100},
+		{"one interval", watts(200), time.Second, 150},
+		// Two intervals weigh the new sample 1-0.5², as if the
+		// missed poll had read 300 too.
+		{"missed poll", watts(300), 3 * time.Second, 150 + 0.75*150},
+		{"out of order", watts(1000), 2 * time.Second, 262.5},
+		{"repeated", watts(1000), 3 * time.Second, 262.5},
+		{"unreported", parseDump(t, withoutLine(testDump, "LOADPCT")), 4 * time.Second, 262.5},
+		// After a gap of 30 intervals the old samples carry
+		// less than a billionth of the weight.
+		{"gap", watts(50), 33 * time.Second, 50},
+	}
+	for _, step := range steps {
+		got := s.Update("ups", step.t, at.Add(step.at))
+		if math.Abs(got-step.want) > 1e-6 {
+			t.Errorf("%s: Update = %v, want %v", step.name, got, step.want)
+		}
+		if w, ok := s.Watts("ups"); !ok || w != got {
+			t.Errorf("%s: Watts = %v, %v, want %v", step.name, w, ok, got)
+		}
+	}
+	if _, ok := s.Watts("other"); ok {
+		t.Error("Watts reports a UPS never updated")
+	}
+	if got := s.Update("other", watts(10), at); got != 10 {
+		t.Errorf("another UPS starts from its first sample: got %v, want 10", got)
+	}
+	if w, _ := s.Watts("ups"); math.Abs(w-50) > 1e-6 {
+		t.Errorf("updating another UPS changed ups to %v", w)
+	}
+
+	// Without an interval every sample carries the weight alpha.
+	s = NewPowerSmoother(0.25, 0)
+	s.Update("ups", watts(100), at)
+	if got := s.Update("ups", watts(200), at.Add(time.Hour)); got != 125 {
+		t.Errorf("without an interval, Update = %v, want 125", got)
+	}
+}
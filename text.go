@@ -0,0 +1,190 @@
+package apcupsc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MarshalText encodes t as a single line of space separated
+// key=value pairs, one per Reported field in Target field order.
+// Strings are quoted as Go string literals, lists of strings are
+// bracketed lists of comma separated quoted strings, timestamps use
+// the time.RFC3339Nano format and durations the time.Duration format,
+// for example:
+//
+//	Power=45 NomPower=900 TimeLeft=1h43m12s Status=["ONLINE"] Name="myapc"
+//
+// The deprecated fields, Raw and Extra are omitted. UnmarshalText decodes
+// this format.
+func (t *Target) MarshalText() ([]byte, error) {
+	var buf bytes.Buffer
+	v := reflect.ValueOf(t).Elem()
+	for _, f := range reflect.VisibleFields(v.Type()) {
+		if !f.IsExported() || deprecatedFields[f.Name] || (!alwaysReported[f.Name] && !t.Reported(f.Name)) {
+			continue
+		}
+		if f.Name == "Raw" || f.Name == "Extra" {
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(f.Name)
+		buf.WriteByte('=')
+		switch x := v.FieldByIndex(f.Index).Interface().(type) {
+		case time.Time:
+			buf.WriteString(x.Format(time.RFC3339Nano))
+		case time.Duration:
+			buf.WriteString(x.String())
+		case string:
+			buf.WriteString(strconv.Quote(x))
+		case []string:
+			buf.WriteByte('[')
+			for i, e := range x {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				buf.WriteString(strconv.Quote(e))
+			}
+			buf.WriteByte(']')
+		case bool:
+			buf.WriteString(strconv.FormatBool(x))
+		case int:
+			buf.WriteString(strconv.Itoa(x))
+		case float64:
+			buf.WriteString(strconv.FormatFloat(x, 'g', -1, 64))
+		default:
+			return nil, fmt.Errorf("unsupported field %s of type %T", f.Name, x)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalText decodes the format produced by MarshalText into t.
+// Unknown keys are ignored, so the format can grow. Only the fields
+// present in text are Reported by the decoded Target.
+func (t *Target) UnmarshalText(text []byte) error {
+	*t = Target{reported: make(map[string]bool)}
+	v := reflect.ValueOf(t).Elem()
+	rest := strings.TrimSpace(string(text))
+	for rest != "" {
+		key, after, ok := strings.Cut(rest, "=")
+		if !ok || key == "" || strings.ContainsRune(key, ' ') {
+			return fmt.Errorf("malformed text near %q", rest)
+		}
+		var value string
+		switch {
+		case strings.HasPrefix(after, `"`):
+			q, err := strconv.QuotedPrefix(after)
+			if err != nil {
+				return fmt.Errorf("bad %s value: %v", key, err)
+			}
+			value, rest = q, after[len(q):]
+		case strings.HasPrefix(after, "["):
+			n, err := listPrefix(after)
+			if err != nil {
+				return fmt.Errorf("bad %s value: %v", key, err)
+			}
+			value, rest = after[:n], after[n:]
+		default:
+			value, rest, _ = strings.Cut(after, " ")
+		}
+		rest = strings.TrimLeft(rest, " ")
+
+		f, ok := v.Type().FieldByName(key)
+		if !ok || !f.IsExported() {
+			continue
+		}
+		fv := v.FieldByIndex(f.Index)
+		var err error
+		switch fv.Interface().(type) {
+		case time.Time:
+			var x time.Time
+			x, err = time.Parse(time.RFC3339Nano, value)
+			fv.Set(reflect.ValueOf(x))
+		case time.Duration:
+			var x time.Duration
+			x, err = time.ParseDuration(value)
+			fv.Set(reflect.ValueOf(x))
+		case string:
+			var x string
+			x, err = strconv.Unquote(value)
+			fv.SetString(x)
+		case []string:
+			var x []string
+			x, err = unquoteList(value)
+			fv.Set(reflect.ValueOf(x))
+		case bool:
+			var x bool
+			x, err = strconv.ParseBool(value)
+			fv.SetBool(x)
+		case int:
+			var x int64
+			x, err = strconv.ParseInt(value, 10, 0)
+			fv.SetInt(x)
+		case float64:
+			var x float64
+			x, err = strconv.ParseFloat(value, 64)
+			fv.SetFloat(x)
+		default:
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("bad %s value %q: %v", key, value, err)
+		}
+		t.report(key)
+	}
+	return nil
+}
+
+// listPrefix returns the length of the bracketed list of quoted
+// strings at the start of s.
+func listPrefix(s string) (int, error) {
+	n := 1
+	if strings.HasPrefix(s[n:], "]") {
+		return n + 1, nil
+	}
+	for {
+		q, err := strconv.QuotedPrefix(s[n:])
+		if err != nil {
+			return 0, err
+		}
+		n += len(q)
+		switch {
+		case strings.HasPrefix(s[n:], ","):
+			n++
+		case strings.HasPrefix(s[n:], "]"):
+			return n + 1, nil
+		default:
+			return 0, fmt.Errorf("unterminated list %q", s)
+		}
+	}
+}
+
+// unquoteList decodes a bracketed list of quoted strings, as written
+// by MarshalText. An empty list decodes as nil.
+func unquoteList(value string) ([]string, error) {
+	if !strings.HasPrefix(value, "[") {
+		return nil, errors.New("not a list")
+	}
+	var list []string
+	rest := value[1:]
+	for !strings.HasPrefix(rest, "]") {
+		q, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return nil, err
+		}
+		e, err := strconv.Unquote(q)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+		rest = strings.TrimPrefix(rest[len(q):], ",")
+	}
+	return list, nil
+}
@@ -0,0 +1,129 @@
+package apcupsc
+
+import (
+	"math"
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// randomString returns a short string that often holds the
+// characters the text format must escape.
+func randomString(r *rand.Rand) string {
+	const tricky = ` ,="\[]` + "\n\t\x00\xff" + `éÿ`
+	var b strings.Builder
+	for range r.IntN(8) {
+		if r.IntN(2) == 0 {
+			b.WriteByte(tricky[r.IntN(len(tricky))])
+		} else {
+			b.WriteByte(byte('a' + r.IntN(26)))
+		}
+	}
+	return b.String()
+}
+
+// randomTarget returns a Target with random values for the fields
+// MarshalText encodes, and a random subset of them Reported.
+func randomTarget(r *rand.Rand) *Target {
+	t := &Target{reported: make(map[string]bool)}
+	v := reflect.ValueOf(t).Elem()
+	for _, f := range reflect.VisibleFields(v.Type()) {
+		if !f.IsExported() || deprecatedFields[f.Name] || f.Name == "Raw" || f.Name == "Extra" {
+			continue
+		}
+		if !alwaysReported[f.Name] && r.IntN(4) == 0 {
+			continue
+		}
+		t.report(f.Name)
+		fv := v.FieldByIndex(f.Index)
+		switch fv.Interface().(type) {
+		case time.Time:
+			zone := time.FixedZone("", (r.IntN(48)-24)*30*60)
+			fv.Set(reflect.ValueOf(time.Unix(r.Int64N(1<<35)-1<<34, r.Int64N(1e9)).In(zone)))
+		case time.Duration:
+			d := time.Duration(r.Int64())
+			if r.IntN(2) == 0 {
+				d = -d
+			}
+			fv.Set(reflect.ValueOf(d))
+		case string:
+			fv.SetString(randomString(r))
+		case []string:
+			var list []string
+			for range r.IntN(4) {
+				list = append(list, randomString(r))
+			}
+			fv.Set(reflect.ValueOf(list))
+		case bool:
+			fv.SetBool(r.IntN(2) == 0)
+		case int:
+			fv.SetInt(int64(r.Uint64()))
+		case float64:
+			x := math.Float64frombits(r.Uint64())
+			if math.IsNaN(x) {
+				x = math.Inf(1)
+			}
+			fv.SetFloat(x)
+		}
+	}
+	return t
+}
+
+func TestTextRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	for i := range 2000 {
+		want := randomTarget(r)
+		text, err := want.MarshalText()
+		if err != nil {
+			t.Fatalf("MarshalText: %v", err)
+		}
+		if strings.ContainsAny(string(text), "\n\r") {
+			t.Fatalf("MarshalText is not a single line: %q", text)
+		}
+		var got Target
+		if err := got.UnmarshalText(text); err != nil {
+			t.Fatalf("UnmarshalText(%q): %v", text, err)
+		}
+		wv, gv := reflect.ValueOf(want).Elem(), reflect.ValueOf(&got).Elem()
+		for _, f := range reflect.VisibleFields(wv.Type()) {
+			if !f.IsExported() || deprecatedFields[f.Name] || f.Name == "Raw" || f.Name == "Extra" {
+				continue
+			}
+			if want.Reported(f.Name) != got.Reported(f.Name) {
+				t.Errorf("%d: %s Reported %v, decoded %v from %q", i, f.Name, want.Reported(f.Name), got.Reported(f.Name), text)
+				continue
+			}
+			w, g := wv.FieldByIndex(f.Index).Interface(), gv.FieldByIndex(f.Index).Interface()
+			same := reflect.DeepEqual(w, g)
+			switch w := w.(type) {
+			case time.Time:
+				same = w.Equal(g.(time.Time))
+			case []string:
+				same = slices.Equal(w, g.([]string))
+			}
+			if !same {
+				t.Errorf("%d: %s is %#v, decoded %#v from %q", i, f.Name, w, g, text)
+			}
+		}
+	}
+}
+
+func TestTextMalformed(t *testing.T) {
+	for _, text := range []string{
+		`Status=["ONLINE"`,
+		`Status=["ONLINE" "LOWBATT"]`,
+		`Status=[ONLINE]`,
+		`Status="ONBATT,LOWBATT"`,
+		`Name="unterminated`,
+		`=5`,
+		`Power=lots`,
+	} {
+		var got Target
+		if err := got.UnmarshalText([]byte(text)); err == nil {
+			t.Errorf("UnmarshalText(%q) succeeded: %+v", text, got)
+		}
+	}
+}
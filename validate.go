@@ -0,0 +1,60 @@
+package apcupsc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrImplausible is wrapped by the errors returned by Validate.
+var ErrImplausible = errors.New("implausible sample")
+
+// DefaultMaxPlausibleRuntime is the longest TimeLeft that Validate
+// accepts, and the longest outage for which Lasted is computed,
+// unless overridden with WithMaxPlausibleRuntime.
+const DefaultMaxPlausibleRuntime = 24 * time.Hour
+
+// maxOverload is the factor by which Power may exceed NomPower before
+// Validate rejects it.
+const maxOverload = 1.5
+
+// Validate checks t for physically implausible values, such as those
+// reported by a daemon with a confused link to its UPS. It returns
+// every problem found, each wrapping ErrImplausible, and no errors
+// for a clean sample. Fields that were not reported are not checked.
+// Of opts, only WithMaxPlausibleRuntime applies.
+func (t *Target) Validate(opts ...Option) []error {
+	maxRuntime := newConfig(opts).maxRuntime
+	var errs []error
+	bad := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrImplausible, fmt.Sprintf(format, args...)))
+	}
+	if t.Reported("LineV") && t.LineV == 0 && t.HasStatus("ONLINE") {
+		bad("LineV is 0 while ONLINE")
+	}
+	if t.Reported("BCharge") && (t.BCharge < 0 || t.BCharge > 100) {
+		bad("BCharge %v outside 0-100 percent", t.BCharge)
+	}
+	for _, d := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"TimeLeft", t.TimeLeft},
+		{"MinTimeLeft", t.MinTimeLeft},
+		{"Lasted", t.Lasted},
+	} {
+		if t.Reported(d.name) && d.d < 0 {
+			bad("%s %v is negative", d.name, d.d)
+		}
+	}
+	if t.Reported("TimeLeft") && t.TimeLeft > maxRuntime {
+		bad("TimeLeft %v exceeds %v", t.TimeLeft, maxRuntime)
+	}
+	if !t.LastOnBattery.IsZero() && !t.SampledAt.IsZero() && t.LastOnBattery.After(t.SampledAt) {
+		bad("LastOnBattery %v is after SampledAt %v", t.LastOnBattery, t.SampledAt)
+	}
+	if t.Reported("Power") && t.Reported("NomPower") && t.NomPower > 0 && float64(t.Power) > maxOverload*float64(t.NomPower) {
+		bad("Power %dW far exceeds NomPower %dW", t.Power, t.NomPower)
+	}
+	return errs
+}
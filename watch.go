@@ -0,0 +1,213 @@
+package apcupsc
+
+import (
+	"context"
+	"math/rand/v2"
+	"net"
+	"time"
+)
+
+// PollInterval is the default time between polls made by Watch. It
+// can be overridden with WithPollInterval.
+var PollInterval = 10 * time.Second
+
+// MaxBackoff is the default cap on the delay between polls of an
+// unreachable service made by Watch. It can be overridden with
+// WithMaxBackoff.
+var MaxBackoff = 5 * time.Minute
+
+// Event is a value sent on the channel returned by Watch. It is one
+// of SampleEvent, DisconnectedEvent, ReconnectedEvent or
+// RestartEvent, one of the alert events: WentOnBatteryEvent,
+// BatteryLowEvent, CommunicationsLostEvent or RecoveredEvent, or a
+// ChargeStateEvent.
+type Event interface {
+	// Time returns when the event was observed.
+	Time() time.Time
+}
+
+// SampleEvent holds a successfully polled status.
+type SampleEvent struct {
+	Addr   string
+	At     time.Time
+	Target *Target
+}
+
+// Time returns when the sample was taken.
+func (e SampleEvent) Time() time.Time { return e.At }
+
+// DisconnectedEvent indicates that an apcupsd service has become
+// unreachable. Err holds the first error of the outage.
+type DisconnectedEvent struct {
+	Addr string
+	At   time.Time
+	Err  error
+}
+
+// Time returns when the service was found to be unreachable.
+func (e DisconnectedEvent) Time() time.Time { return e.At }
+
+// ReconnectedEvent indicates that a previously unreachable apcupsd
+// service is answering again. It is followed by a SampleEvent.
+type ReconnectedEvent struct {
+	Addr string
+	At   time.Time
+}
+
+// Time returns when the service was reached again.
+func (e ReconnectedEvent) Time() time.Time { return e.At }
+
+// RestartEvent indicates that the apcupsd daemon has restarted since
+// the previous sample, as shown by a change in its StartTime.
+// Counters such as XFers restart from zero after a daemon restart. It
+// is followed by the SampleEvent of the restarted daemon.
+type RestartEvent struct {
+	Addr string
+	At   time.Time
+	// Previous and Current are the old and new daemon StartTimes.
+	Previous, Current time.Time
+}
+
+// Time returns when the restart was detected.
+func (e RestartEvent) Time() time.Time { return e.At }
+
+// Watch polls the apcupsd service at ep until ctx is cancelled,
+// sending the results as Events on the returned channel. Polls are
+// spaced by the poll interval (see WithPollInterval). While the
+// service is unreachable, polls back off exponentially up to the
+// maximum backoff (see WithMaxBackoff), and a single
+// DisconnectedEvent is sent per outage. Alert events are sent only
+// when an alert condition changes, ahead of the SampleEvent that
+// revealed the change. The channel is closed once ctx is cancelled.
+func Watch(ctx context.Context, ep string, opts ...Option) (<-chan Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c := NewClient(ep, opts...)
+	if _, _, err := net.SplitHostPort(c.Addr()); err != nil {
+		return nil, err
+	}
+	return newConfig(opts).watch(ctx, c, func() { c.Close() }), nil
+}
+
+// WatchSource is a variant of Watch that polls any StatusSource. The
+// opts that control polling, such as WithPollInterval, are honored.
+func WatchSource(ctx context.Context, c StatusSource, opts ...Option) (<-chan Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return newConfig(opts).watch(ctx, c, func() {}), nil
+}
+
+// watch polls c until ctx is cancelled, calling done before closing
+// the returned channel.
+func (cfg *config) watch(ctx context.Context, c StatusSource, done func()) <-chan Event {
+	ch := make(chan Event)
+	go func() {
+		defer close(ch)
+		defer done()
+		send := func(e Event) bool {
+			select {
+			case ch <- e:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		alerts := &alerter{cfg: cfg, addr: c.Addr()}
+		var charge *ChargeTracker
+		if cfg.chargeEnter > 0 {
+			charge = NewChargeTracker(cfg.chargeEnter, cfg.chargeExit)
+		}
+		down := false
+		var started time.Time
+		delay := cfg.interval
+		if !sleep(ctx, cfg.startDelay) {
+			return
+		}
+		for {
+			t, err := c.Status(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			now := time.Now()
+			if cfg.polled != nil {
+				cfg.polled(t, err, now)
+			}
+			if err != nil {
+				if !down {
+					down = true
+					delay = cfg.interval
+					if !send(DisconnectedEvent{Addr: c.Addr(), At: now, Err: err}) {
+						return
+					}
+				} else if delay = 2 * delay; delay > cfg.maxBackoff {
+					delay = max(cfg.maxBackoff, cfg.interval)
+				}
+				for _, e := range alerts.failure(err, now) {
+					if !send(e) {
+						return
+					}
+				}
+			} else {
+				if down {
+					down = false
+					if !send(ReconnectedEvent{Addr: c.Addr(), At: now}) {
+						return
+					}
+				}
+				delay = cfg.interval
+				if !started.IsZero() && !t.StartTime.IsZero() && !t.StartTime.Equal(started) {
+					if !send(RestartEvent{Addr: c.Addr(), At: now, Previous: started, Current: t.StartTime}) {
+						return
+					}
+				}
+				if !t.StartTime.IsZero() {
+					started = t.StartTime
+				}
+				for _, e := range alerts.sample(t, now) {
+					if !send(e) {
+						return
+					}
+				}
+				if charge != nil {
+					was := charge.State()
+					if state, changed := charge.Update(t); changed && was != ChargeUnknown {
+						if !send(ChargeStateEvent{Addr: c.Addr(), At: now, Target: t, Previous: was, Current: state}) {
+							return
+						}
+					}
+				}
+				for _, sink := range cfg.sinks {
+					sink(c.Addr(), t, now)
+				}
+				if !send(SampleEvent{Addr: c.Addr(), At: now, Target: t}) {
+					return
+				}
+			}
+			wait := delay
+			if cfg.jitter > 0 {
+				wait += rand.N(cfg.jitter)
+			}
+			if !sleep(ctx, wait) {
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// sleep waits for d, returning false if ctx is cancelled first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,167 @@
+package apcupsc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchOutage(t *testing.T) {
+	onBattery := withLine(withLine(testDump, "STATUS", "ONBATT"), "BCHARGE", "80.0 Percent")
+	low := withLine(withLine(onBattery, "STATUS", "ONBATT LOWBATT"), "BCHARGE", "20.0 Percent")
+	trim := withLine(withLine(testDump, "STATUS", "TRIM ONLINE"), "BCHARGE", "25.0 Percent")
+	dumps := []string{testDump, onBattery, low, trim}
+	addr, _ := startNIS(t, func(n int) []byte {
+		switch {
+		case n < len(dumps):
+			return frameLines(dumps[n])
+		case n < len(dumps)+4:
+			// The daemon goes away for a few polls.
+			return nil
+		}
+		return frameLines(testDump)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ch, err := Watch(ctx, addr, WithPollInterval(time.Millisecond), WithCommLostAfter(2))
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	want := []string{
+		"Sample ONLINE",
+		"WentOnBattery ONBATT", "Sample ONBATT",
+		"BatteryLow ONBATT LOWBATT", "Sample ONBATT LOWBATT",
+		"Recovered TRIM ONLINE", "Sample TRIM ONLINE",
+		"Disconnected", "CommunicationsLost",
+		"Reconnected", "Recovered ONLINE", "Sample ONLINE",
+	}
+	var got []string
+	for e := range ch {
+		var s string
+		switch e := e.(type) {
+		case SampleEvent:
+			s = "Sample " + strings.Join(e.Target.Status, " ")
+			if e.Target.Offline != e.Target.HasStatus("ONBATT") {
+				t.Errorf("Offline is %v for STATUS %q", e.Target.Offline, e.Target.Status)
+			}
+		case WentOnBatteryEvent:
+			s = "WentOnBattery " + strings.Join(e.Target.Status, " ")
+		case BatteryLowEvent:
+			s = "BatteryLow " + strings.Join(e.Target.Status, " ")
+		case RecoveredEvent:
+			s = "Recovered " + strings.Join(e.Target.Status, " ")
+		case CommunicationsLostEvent:
+			s = "CommunicationsLost"
+		case DisconnectedEvent:
+			s = "Disconnected"
+		case ReconnectedEvent:
+			s = "Reconnected"
+		default:
+			s = fmt.Sprintf("%T", e)
+		}
+		got = append(got, s)
+		if len(got) == len(want) {
+			cancel()
+		}
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got events:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestWatchRestart(t *testing.T) {
+	restarted := withLine(withLine(withLine(testDump,
+		"STARTTIME", "2024-10-19 11:40:00 -0700"),
+		"NUMXFERS", "0"),
+		"XONBATT", "N/A")
+	addr, _ := startNIS(t, func(n int) []byte {
+		switch {
+		case n < 2:
+			return frameLines(testDump)
+		case n == 4:
+			// Reconnecting to the same daemon is not a restart.
+			return nil
+		}
+		return frameLines(restarted)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ch, err := Watch(ctx, addr, WithPollInterval(time.Millisecond))
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	var (
+		restarts []RestartEvent
+		samples  int
+	)
+	for e := range ch {
+		switch e := e.(type) {
+		case RestartEvent:
+			if samples != 2 {
+				t.Errorf("RestartEvent after %d samples, want 2", samples)
+			}
+			restarts = append(restarts, e)
+		case SampleEvent:
+			if samples++; samples == 8 {
+				cancel()
+			}
+		}
+	}
+	if len(restarts) != 1 {
+		t.Fatalf("got %d RestartEvents, want 1: %v", len(restarts), restarts)
+	}
+	prev := time.Date(2024, 10, 1, 10, 0, 0, 0, time.FixedZone("", -7*3600))
+	cur := time.Date(2024, 10, 19, 11, 40, 0, 0, time.FixedZone("", -7*3600))
+	if e := restarts[0]; e.Addr != addr || !e.Previous.Equal(prev) || !e.Current.Equal(cur) {
+		t.Errorf("RestartEvent = %+v, want from %v to %v at %s", e, prev, cur, addr)
+	}
+}
+
+func TestWatchSource(t *testing.T) {
+	down := errors.New("down")
+	ok := &Target{Name: "stub", Status: []string{"ONLINE"}}
+	src := &stubSource{addr: "stub", results: []stubResult{{t: ok}, {err: down}, {err: down}, {t: ok}}}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ch, err := WatchSource(ctx, src, WithPollInterval(time.Millisecond))
+	if err != nil {
+		t.Fatalf("WatchSource: %v", err)
+	}
+	want := []string{"Sample stub", "Disconnected stub down", "Reconnected stub", "Sample stub"}
+	var got []string
+	for e := range ch {
+		switch e := e.(type) {
+		case SampleEvent:
+			got = append(got, "Sample "+e.Addr)
+			if e.Target.Name != "stub" {
+				t.Errorf("sample of %q, want the stub's", e.Target.Name)
+			}
+		case DisconnectedEvent:
+			got = append(got, fmt.Sprintf("Disconnected %s %v", e.Addr, e.Err))
+			if !errors.Is(e.Err, down) {
+				t.Errorf("Disconnected with %v, want the stub's error", e.Err)
+			}
+		case ReconnectedEvent:
+			got = append(got, "Reconnected "+e.Addr)
+		default:
+			got = append(got, fmt.Sprintf("%T", e))
+		}
+		if len(got) == len(want) {
+			cancel()
+		}
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got events:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	cancelled, stop := context.WithCancel(context.Background())
+	stop()
+	if _, err := WatchSource(cancelled, src); !errors.Is(err, context.Canceled) {
+		t.Errorf("WatchSource with a cancelled context: %v, want context.Canceled", err)
+	}
+}